@@ -0,0 +1,782 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NebojsaJovanovic95/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// fakeDB is an in-memory database.Querier used by the handler tests.
+type fakeDB struct {
+	users         map[uuid.UUID]database.User
+	chirps        []database.Chirp
+	refreshTokens map[string]database.RefreshToken
+	likes         map[uuid.UUID]map[uuid.UUID]bool
+	tags          map[uuid.UUID]map[string]bool
+	mentions      map[uuid.UUID]map[uuid.UUID]bool
+	follows       map[uuid.UUID]map[uuid.UUID]bool
+	resetTokens   map[string]database.PasswordResetToken
+	verifyTokens  map[string]database.EmailVerificationToken
+	webhooks      map[string]bool
+	clock         time.Time
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		users:         map[uuid.UUID]database.User{},
+		refreshTokens: map[string]database.RefreshToken{},
+		likes:         map[uuid.UUID]map[uuid.UUID]bool{},
+		tags:          map[uuid.UUID]map[string]bool{},
+		mentions:      map[uuid.UUID]map[uuid.UUID]bool{},
+		follows:       map[uuid.UUID]map[uuid.UUID]bool{},
+		resetTokens:   map[string]database.PasswordResetToken{},
+		verifyTokens:  map[string]database.EmailVerificationToken{},
+		webhooks:      map[string]bool{},
+		clock:         time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// page applies LIMIT/OFFSET semantics to an already ordered slice.
+func page[T any](items []T, limit, offset int32) []T {
+	if int(offset) >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if int(limit) < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// inWindow reports whether t falls in [start, end), treating an unset bound
+// as open.
+func inWindow(t time.Time, start, end sql.NullTime) bool {
+	return (!start.Valid || !t.Before(start.Time)) && (!end.Valid || t.Before(end.Time))
+}
+
+// listChirps filters, orders by created_at and pages the stored chirps the
+// same way the list queries do.
+func (f *fakeDB) listChirps(match func(database.Chirp) bool, desc bool, limit, offset int32) []database.Chirp {
+	var chirps []database.Chirp
+	for _, c := range f.chirps {
+		if !c.DeletedAt.Valid && match(c) {
+			chirps = append(chirps, c)
+		}
+	}
+	sort.SliceStable(chirps, func(i, j int) bool {
+		if desc {
+			return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
+		}
+		return chirps[i].CreatedAt.Before(chirps[j].CreatedAt)
+	})
+	return page(chirps, limit, offset)
+}
+
+// orphanReplies clears parent_id on replies whose parent is gone, like the
+// ON DELETE SET NULL foreign key.
+func (f *fakeDB) orphanReplies() {
+	live := map[uuid.UUID]bool{}
+	for _, c := range f.chirps {
+		live[c.ID] = true
+	}
+	for i, c := range f.chirps {
+		if c.ParentID.Valid && !live[c.ParentID.UUID] {
+			f.chirps[i].ParentID = uuid.NullUUID{}
+		}
+	}
+}
+
+// withLikes pairs each chirp with its like count, like the list queries'
+// chirp_likes subselect.
+func withLikes[R ~struct {
+	Chirp database.Chirp
+	Likes int64
+}](f *fakeDB, chirps []database.Chirp) []R {
+	rows := make([]R, 0, len(chirps))
+	for _, c := range chirps {
+		rows = append(rows, R{Chirp: c, Likes: int64(len(f.likes[c.ID]))})
+	}
+	return rows
+}
+
+// uniqueViolation mirrors the error Postgres returns for a duplicate email.
+var uniqueViolation = &pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"}
+
+// foreignKeyViolation is the error for a row referencing a missing user.
+var foreignKeyViolation = &pq.Error{Code: "23503", Message: "insert or update violates foreign key constraint"}
+
+// usernameViolation is the error for a duplicate username.
+var usernameViolation = &pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint", Constraint: usernameIndex}
+
+// emailTaken reports whether another user than except already uses email.
+// It compares exactly, so tests see whether handlers lowercase emails
+// before they reach the users_email_lower_idx index.
+func (f *fakeDB) emailTaken(email string, except uuid.UUID) bool {
+	for _, u := range f.users {
+		if u.ID != except && u.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// createChirpsTx stands in for database.CreateChirpsTx. Nothing here can
+// fail midway, so there is never anything to roll back.
+func (f *fakeDB) createChirpsTx(ctx context.Context, params []database.CreateChirpParams) ([]database.Chirp, error) {
+	chirps := make([]database.Chirp, 0, len(params))
+	for _, p := range params {
+		chirp, _ := f.CreateChirp(ctx, p)
+		chirps = append(chirps, chirp)
+	}
+	return chirps, nil
+}
+
+// tick advances the fake clock so consecutive rows get distinct timestamps.
+func (f *fakeDB) tick() time.Time {
+	f.clock = f.clock.Add(time.Second)
+	return f.clock
+}
+
+func (f *fakeDB) ConsumePasswordResetToken(ctx context.Context, token string) (uuid.UUID, error) {
+	rt, ok := f.resetTokens[token]
+	if !ok || rt.UsedAt.Valid || !rt.ExpiresAt.After(time.Now()) {
+		return uuid.Nil, sql.ErrNoRows
+	}
+	rt.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	f.resetTokens[token] = rt
+	return rt.UserID, nil
+}
+
+func (f *fakeDB) ConsumeVerificationToken(ctx context.Context, token string) (uuid.UUID, error) {
+	vt, ok := f.verifyTokens[token]
+	if !ok || !vt.ExpiresAt.After(time.Now()) {
+		return uuid.Nil, sql.ErrNoRows
+	}
+	delete(f.verifyTokens, token)
+	return vt.UserID, nil
+}
+
+func (f *fakeDB) CountChirps(ctx context.Context) (int64, error) {
+	var n int64
+	for _, c := range f.chirps {
+		if !c.DeletedAt.Valid {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeDB) CountChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var n int64
+	for _, c := range f.chirps {
+		if c.UserID == userID && !c.DeletedAt.Valid {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeDB) CountLikes(ctx context.Context, chirpID uuid.UUID) (int64, error) {
+	return int64(len(f.likes[chirpID])), nil
+}
+
+func (f *fakeDB) CountUserChirpsSince(ctx context.Context, arg database.CountUserChirpsSinceParams) (int64, error) {
+	var n int64
+	for _, c := range f.chirps {
+		if c.UserID == arg.UserID && c.CreatedAt.After(arg.Since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeDB) CountUsers(ctx context.Context) (int64, error) {
+	return int64(len(f.users)), nil
+}
+
+func (f *fakeDB) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	now := f.tick()
+	chirp := database.Chirp{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Body:      arg.Body,
+		UserID:    arg.UserID,
+		ParentID:  arg.ParentID,
+	}
+	f.chirps = append(f.chirps, chirp)
+	return chirp, nil
+}
+
+func (f *fakeDB) CreateChirpMention(ctx context.Context, arg database.CreateChirpMentionParams) error {
+	if f.mentions[arg.ChirpID] == nil {
+		f.mentions[arg.ChirpID] = map[uuid.UUID]bool{}
+	}
+	f.mentions[arg.ChirpID][arg.UserID] = true
+	return nil
+}
+
+func (f *fakeDB) CreateChirpTag(ctx context.Context, arg database.CreateChirpTagParams) error {
+	if f.tags[arg.ChirpID] == nil {
+		f.tags[arg.ChirpID] = map[string]bool{}
+	}
+	f.tags[arg.ChirpID][arg.Tag] = true
+	return nil
+}
+
+func (f *fakeDB) CreateFollow(ctx context.Context, arg database.CreateFollowParams) error {
+	if _, ok := f.users[arg.FollowerID]; !ok {
+		return foreignKeyViolation
+	}
+	if f.follows[arg.FollowerID] == nil {
+		f.follows[arg.FollowerID] = map[uuid.UUID]bool{}
+	}
+	f.follows[arg.FollowerID][arg.FolloweeID] = true
+	return nil
+}
+
+func (f *fakeDB) CreateLike(ctx context.Context, arg database.CreateLikeParams) error {
+	if _, ok := f.users[arg.UserID]; !ok {
+		return foreignKeyViolation
+	}
+	if f.likes[arg.ChirpID] == nil {
+		f.likes[arg.ChirpID] = map[uuid.UUID]bool{}
+	}
+	f.likes[arg.ChirpID][arg.UserID] = true
+	return nil
+}
+
+func (f *fakeDB) CreatePasswordResetToken(ctx context.Context, arg database.CreatePasswordResetTokenParams) error {
+	f.resetTokens[arg.Token] = database.PasswordResetToken{
+		Token:     arg.Token,
+		UserID:    arg.UserID,
+		CreatedAt: f.tick(),
+		ExpiresAt: arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (f *fakeDB) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) error {
+	now := f.tick()
+	f.refreshTokens[arg.Token] = database.RefreshToken{
+		Token:     arg.Token,
+		UserID:    arg.UserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	return nil
+}
+
+// CreateUser seeds a user with only an email. It isn't part of
+// database.Querier; tests set up users with it and CreateUserWithPassword
+// builds on it.
+func (f *fakeDB) CreateUser(ctx context.Context, email string) (database.User, error) {
+	if f.emailTaken(email, uuid.Nil) {
+		return database.User{}, uniqueViolation
+	}
+	now := f.tick()
+	user := database.User{
+		ID:             uuid.New(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Email:          email,
+		HashedPassword: "unset",
+	}
+	f.users[user.ID] = user
+	return user, nil
+}
+
+func (f *fakeDB) CreateUserWithPassword(ctx context.Context, arg database.CreateUserWithPasswordParams) (database.CreateUserWithPasswordRow, error) {
+	if arg.Username.Valid {
+		if _, err := f.GetUserByUsername(ctx, arg.Username.String); err == nil {
+			return database.CreateUserWithPasswordRow{}, usernameViolation
+		}
+	}
+	user, err := f.CreateUser(ctx, arg.Email)
+	if err != nil {
+		return database.CreateUserWithPasswordRow{}, err
+	}
+	user.HashedPassword = arg.HashedPassword
+	user.Username = arg.Username
+	f.users[user.ID] = user
+	return database.CreateUserWithPasswordRow{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+		Username:    user.Username,
+		AvatarUrl:   user.AvatarUrl,
+		Bio:         user.Bio,
+	}, nil
+}
+
+func (f *fakeDB) CreateVerificationToken(ctx context.Context, arg database.CreateVerificationTokenParams) error {
+	f.verifyTokens[arg.Token] = database.EmailVerificationToken{
+		Token:     arg.Token,
+		UserID:    arg.UserID,
+		CreatedAt: f.tick(),
+		ExpiresAt: arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (f *fakeDB) DeleteAllUsers(ctx context.Context) error {
+	f.users = map[uuid.UUID]database.User{}
+	f.chirps = nil
+	f.refreshTokens = map[string]database.RefreshToken{}
+	f.likes = map[uuid.UUID]map[uuid.UUID]bool{}
+	f.follows = map[uuid.UUID]map[uuid.UUID]bool{}
+	return nil
+}
+
+func (f *fakeDB) DeleteAllChirps(ctx context.Context) error {
+	f.chirps = nil
+	f.likes = map[uuid.UUID]map[uuid.UUID]bool{}
+	return nil
+}
+
+func (f *fakeDB) DeleteChirpMentions(ctx context.Context, chirpID uuid.UUID) error {
+	delete(f.mentions, chirpID)
+	return nil
+}
+
+func (f *fakeDB) DeleteChirpTags(ctx context.Context, chirpID uuid.UUID) error {
+	delete(f.tags, chirpID)
+	return nil
+}
+
+func (f *fakeDB) DeleteFollow(ctx context.Context, arg database.DeleteFollowParams) error {
+	delete(f.follows[arg.FollowerID], arg.FolloweeID)
+	return nil
+}
+
+func (f *fakeDB) DeleteLike(ctx context.Context, arg database.DeleteLikeParams) error {
+	delete(f.likes[arg.ChirpID], arg.UserID)
+	return nil
+}
+
+func (f *fakeDB) DeleteProcessedWebhook(ctx context.Context, eventID string) error {
+	delete(f.webhooks, eventID)
+	return nil
+}
+
+func (f *fakeDB) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	if _, ok := f.users[id]; !ok {
+		return 0, nil
+	}
+	delete(f.users, id)
+	chirps := f.chirps[:0]
+	for _, c := range f.chirps {
+		if c.UserID == id {
+			delete(f.likes, c.ID)
+			continue
+		}
+		chirps = append(chirps, c)
+	}
+	f.chirps = chirps
+	f.orphanReplies()
+	for chirpID := range f.likes {
+		delete(f.likes[chirpID], id)
+	}
+	delete(f.follows, id)
+	for followerID := range f.follows {
+		delete(f.follows[followerID], id)
+	}
+	for token, rt := range f.refreshTokens {
+		if rt.UserID.UUID == id {
+			delete(f.refreshTokens, token)
+		}
+	}
+	return 1, nil
+}
+
+func (f *fakeDB) DowngradeUserFromChirpyRed(ctx context.Context, id uuid.UUID) (int64, error) {
+	return f.setChirpyRed(id, false), nil
+}
+
+func (f *fakeDB) GetActiveRefreshTokensForUser(ctx context.Context, userID uuid.NullUUID) ([]database.RefreshToken, error) {
+	var tokens []database.RefreshToken
+	for _, rt := range f.refreshTokens {
+		if rt.UserID == userID && !rt.RevokedAt.Valid && rt.ExpiresAt.After(time.Now()) {
+			tokens = append(tokens, rt)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+func (f *fakeDB) GetChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	for _, c := range f.chirps {
+		if c.ID == id && !c.DeletedAt.Valid {
+			return c, nil
+		}
+	}
+	return database.Chirp{}, sql.ErrNoRows
+}
+
+func (f *fakeDB) GetChirpReplies(ctx context.Context, parentID uuid.NullUUID) ([]database.GetChirpRepliesRow, error) {
+	return withLikes[database.GetChirpRepliesRow](f, f.listChirps(func(c database.Chirp) bool {
+		return c.ParentID.Valid && c.ParentID == parentID
+	}, false, int32(len(f.chirps)), 0)), nil
+}
+
+func (f *fakeDB) GetChirpsAfter(ctx context.Context, arg database.GetChirpsAfterParams) ([]database.GetChirpsAfterRow, error) {
+	chirps := f.listChirps(func(c database.Chirp) bool {
+		after := c.CreatedAt.After(arg.CursorCreatedAt) ||
+			(c.CreatedAt.Equal(arg.CursorCreatedAt) && bytes.Compare(c.ID[:], arg.CursorID[:]) > 0)
+		return after && (!arg.AuthorID.Valid || c.UserID == arg.AuthorID.UUID)
+	}, false, arg.RowLimit, 0)
+	return withLikes[database.GetChirpsAfterRow](f, chirps), nil
+}
+
+func (f *fakeDB) GetChirpsBefore(ctx context.Context, arg database.GetChirpsBeforeParams) ([]database.GetChirpsBeforeRow, error) {
+	chirps := f.listChirps(func(c database.Chirp) bool {
+		before := !arg.CursorCreatedAt.Valid || c.CreatedAt.Before(arg.CursorCreatedAt.Time) ||
+			(c.CreatedAt.Equal(arg.CursorCreatedAt.Time) && bytes.Compare(c.ID[:], arg.CursorID.UUID[:]) < 0)
+		return before && (!arg.AuthorID.Valid || c.UserID == arg.AuthorID.UUID)
+	}, true, arg.RowLimit, 0)
+	return withLikes[database.GetChirpsBeforeRow](f, chirps), nil
+}
+
+func (f *fakeDB) GetChirpsByTag(ctx context.Context, arg database.GetChirpsByTagParams) ([]database.GetChirpsByTagRow, error) {
+	return withLikes[database.GetChirpsByTagRow](f, f.listChirps(func(c database.Chirp) bool {
+		return f.tags[c.ID][arg.Tag]
+	}, true, arg.Limit, arg.Offset)), nil
+}
+
+func (f *fakeDB) GetChirpsMentioningUser(ctx context.Context, arg database.GetChirpsMentioningUserParams) ([]database.GetChirpsMentioningUserRow, error) {
+	return withLikes[database.GetChirpsMentioningUserRow](f, f.listChirps(func(c database.Chirp) bool {
+		return f.mentions[c.ID][arg.UserID]
+	}, true, arg.Limit, arg.Offset)), nil
+}
+
+func (f *fakeDB) GetChirpsPaged(ctx context.Context, arg database.GetChirpsPagedParams) ([]database.GetChirpsPagedRow, error) {
+	return withLikes[database.GetChirpsPagedRow](f, f.listChirps(func(c database.Chirp) bool {
+		return (!arg.AuthorID.Valid || c.UserID == arg.AuthorID.UUID) && inWindow(c.CreatedAt, arg.Start, arg.End)
+	}, arg.SortDesc, arg.RowLimit, arg.RowOffset)), nil
+}
+
+func (f *fakeDB) GetChirpsWithAuthor(ctx context.Context, arg database.GetChirpsWithAuthorParams) ([]database.GetChirpsWithAuthorRow, error) {
+	chirps, _ := f.GetChirpsPaged(ctx, database.GetChirpsPagedParams(arg))
+	var rows []database.GetChirpsWithAuthorRow
+	for _, row := range chirps {
+		c := row.Chirp
+		u, ok := f.users[c.UserID]
+		if !ok {
+			continue
+		}
+		rows = append(rows, database.GetChirpsWithAuthorRow{
+			ID:          c.ID,
+			CreatedAt:   c.CreatedAt,
+			UpdatedAt:   c.UpdatedAt,
+			Body:        c.Body,
+			UserID:      c.UserID,
+			ParentID:    c.ParentID,
+			DeletedAt:   c.DeletedAt,
+			AuthorEmail: u.Email,
+			Likes:       row.Likes,
+		})
+	}
+	return rows, nil
+}
+
+func (f *fakeDB) GetDeletedChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	for _, c := range f.chirps {
+		if c.ID == id && c.DeletedAt.Valid {
+			return c, nil
+		}
+	}
+	return database.Chirp{}, sql.ErrNoRows
+}
+
+func (f *fakeDB) GetFeed(ctx context.Context, arg database.GetFeedParams) ([]database.GetFeedRow, error) {
+	return withLikes[database.GetFeedRow](f, f.listChirps(func(c database.Chirp) bool {
+		return f.follows[arg.FollowerID][c.UserID]
+	}, true, arg.Limit, arg.Offset)), nil
+}
+
+func (f *fakeDB) GetRefreshToken(ctx context.Context, token string) (database.RefreshToken, error) {
+	rt, ok := f.refreshTokens[token]
+	if !ok {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	return rt, nil
+}
+
+func (f *fakeDB) GetUserByEmail(ctx context.Context, email string) (database.GetUserByEmailRow, error) {
+	for _, u := range f.users {
+		if u.Email == email {
+			return database.GetUserByEmailRow{
+				ID:             u.ID,
+				Email:          u.Email,
+				CreatedAt:      u.CreatedAt,
+				UpdatedAt:      u.UpdatedAt,
+				HashedPassword: u.HashedPassword,
+				IsChirpyRed:    u.IsChirpyRed,
+				Username:       u.Username,
+				AvatarUrl:      u.AvatarUrl,
+				Bio:            u.Bio,
+			}, nil
+		}
+	}
+	return database.GetUserByEmailRow{}, sql.ErrNoRows
+}
+
+func (f *fakeDB) GetUserByID(ctx context.Context, id uuid.UUID) (database.GetUserByIDRow, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return database.GetUserByIDRow{}, sql.ErrNoRows
+	}
+	return database.GetUserByIDRow{
+		ID:          u.ID,
+		Email:       u.Email,
+		CreatedAt:   u.CreatedAt,
+		UpdatedAt:   u.UpdatedAt,
+		IsChirpyRed: u.IsChirpyRed,
+		IsVerified:  u.IsVerified,
+		Username:    u.Username,
+		AvatarUrl:   u.AvatarUrl,
+		Bio:         u.Bio,
+	}, nil
+}
+
+func (f *fakeDB) GetUserByUsername(ctx context.Context, username string) (database.GetUserByUsernameRow, error) {
+	for _, u := range f.users {
+		if u.Username.Valid && strings.EqualFold(u.Username.String, username) {
+			return database.GetUserByUsernameRow{
+				ID:             u.ID,
+				Email:          u.Email,
+				CreatedAt:      u.CreatedAt,
+				UpdatedAt:      u.UpdatedAt,
+				HashedPassword: u.HashedPassword,
+				IsChirpyRed:    u.IsChirpyRed,
+				Username:       u.Username,
+				AvatarUrl:      u.AvatarUrl,
+				Bio:            u.Bio,
+			}, nil
+		}
+	}
+	return database.GetUserByUsernameRow{}, sql.ErrNoRows
+}
+
+func (f *fakeDB) GetUserFromValidRefreshToken(ctx context.Context, token string) (database.GetUserFromValidRefreshTokenRow, error) {
+	rt, ok := f.refreshTokens[token]
+	if !ok || rt.RevokedAt.Valid || !rt.ExpiresAt.After(time.Now()) {
+		return database.GetUserFromValidRefreshTokenRow{}, sql.ErrNoRows
+	}
+	u, ok := f.users[rt.UserID.UUID]
+	if !ok {
+		return database.GetUserFromValidRefreshTokenRow{}, sql.ErrNoRows
+	}
+	return database.GetUserFromValidRefreshTokenRow{
+		ID:        u.ID,
+		Email:     u.Email,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}, nil
+}
+
+func (f *fakeDB) MarkUserVerified(ctx context.Context, id uuid.UUID) error {
+	u, ok := f.users[id]
+	if !ok {
+		return nil
+	}
+	u.IsVerified = true
+	u.UpdatedAt = f.tick()
+	f.users[id] = u
+	return nil
+}
+
+func (f *fakeDB) PurgeDeletedChirps(ctx context.Context, deletedAt sql.NullTime) (int64, error) {
+	var kept []database.Chirp
+	var purged int64
+	for _, c := range f.chirps {
+		if c.DeletedAt.Valid && c.DeletedAt.Time.Before(deletedAt.Time) {
+			delete(f.likes, c.ID)
+			purged++
+			continue
+		}
+		kept = append(kept, c)
+	}
+	f.chirps = kept
+	f.orphanReplies()
+	return purged, nil
+}
+
+func (f *fakeDB) RecordProcessedWebhook(ctx context.Context, eventID string) (int64, error) {
+	if f.webhooks[eventID] {
+		return 0, nil
+	}
+	f.webhooks[eventID] = true
+	return 1, nil
+}
+
+func (f *fakeDB) RevokeActiveRefreshToken(ctx context.Context, token string) (int64, error) {
+	rt, ok := f.refreshTokens[token]
+	if !ok || rt.RevokedAt.Valid {
+		return 0, nil
+	}
+	now := f.tick()
+	rt.RevokedAt = sql.NullTime{Time: now, Valid: true}
+	rt.UpdatedAt = now
+	f.refreshTokens[token] = rt
+	return 1, nil
+}
+
+func (f *fakeDB) RevokeAllRefreshTokensForUser(ctx context.Context, userID uuid.NullUUID) error {
+	now := f.tick()
+	for token, rt := range f.refreshTokens {
+		if rt.UserID == userID && !rt.RevokedAt.Valid {
+			rt.RevokedAt = sql.NullTime{Time: now, Valid: true}
+			rt.UpdatedAt = now
+			f.refreshTokens[token] = rt
+		}
+	}
+	return nil
+}
+
+func (f *fakeDB) RevokeRefreshToken(ctx context.Context, arg database.RevokeRefreshTokenParams) error {
+	rt, ok := f.refreshTokens[arg.Token]
+	if !ok {
+		return nil
+	}
+	rt.RevokedAt = arg.RevokedAt
+	rt.UpdatedAt = arg.UpdatedAt
+	f.refreshTokens[arg.Token] = rt
+	return nil
+}
+
+func (f *fakeDB) RestoreChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	for i, c := range f.chirps {
+		if c.ID == id && c.DeletedAt.Valid {
+			c.DeletedAt = sql.NullTime{}
+			c.UpdatedAt = f.tick()
+			f.chirps[i] = c
+			return c, nil
+		}
+	}
+	return database.Chirp{}, sql.ErrNoRows
+}
+
+func (f *fakeDB) SearchChirps(ctx context.Context, arg database.SearchChirpsParams) ([]database.SearchChirpsRow, error) {
+	query := strings.NewReplacer(`\\`, `\`, `\%`, "%", `\_`, "_").Replace(arg.Query)
+	return withLikes[database.SearchChirpsRow](f, f.listChirps(func(c database.Chirp) bool {
+		if arg.AuthorID.Valid && c.UserID != arg.AuthorID.UUID {
+			return false
+		}
+		if !inWindow(c.CreatedAt, arg.Start, arg.End) {
+			return false
+		}
+		return strings.Contains(strings.ToLower(c.Body), strings.ToLower(query))
+	}, arg.SortDesc, arg.RowLimit, arg.RowOffset)), nil
+}
+
+// SoftDeleteChirp stamps deleted_at with the wall clock, like NOW() would,
+// so handlers can measure the restore window against time.Now.
+func (f *fakeDB) SoftDeleteChirp(ctx context.Context, id uuid.UUID) error {
+	for i, c := range f.chirps {
+		if c.ID == id && !c.DeletedAt.Valid {
+			c.DeletedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+			c.UpdatedAt = f.tick()
+			f.chirps[i] = c
+		}
+	}
+	return nil
+}
+
+func (f *fakeDB) SoftDeleteChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var n int64
+	for i, c := range f.chirps {
+		if c.UserID == userID && !c.DeletedAt.Valid {
+			c.DeletedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+			c.UpdatedAt = f.tick()
+			f.chirps[i] = c
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeDB) UpdateChirp(ctx context.Context, arg database.UpdateChirpParams) (database.Chirp, error) {
+	for i, c := range f.chirps {
+		if c.ID == arg.ID && !c.DeletedAt.Valid {
+			c.Body = arg.Body
+			c.UpdatedAt = f.tick()
+			f.chirps[i] = c
+			return c, nil
+		}
+	}
+	return database.Chirp{}, sql.ErrNoRows
+}
+
+func (f *fakeDB) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.UpdateUserRow, error) {
+	u, ok := f.users[arg.ID]
+	if !ok {
+		return database.UpdateUserRow{}, sql.ErrNoRows
+	}
+	if arg.Email.Valid {
+		if f.emailTaken(arg.Email.String, arg.ID) {
+			return database.UpdateUserRow{}, uniqueViolation
+		}
+		u.Email = arg.Email.String
+	}
+	if arg.HashedPassword.Valid {
+		u.HashedPassword = arg.HashedPassword.String
+	}
+	if arg.AvatarUrl.Valid {
+		u.AvatarUrl = arg.AvatarUrl.String
+	}
+	if arg.Bio.Valid {
+		u.Bio = arg.Bio.String
+	}
+	u.UpdatedAt = f.tick()
+	f.users[u.ID] = u
+	return database.UpdateUserRow{
+		ID:          u.ID,
+		Email:       u.Email,
+		CreatedAt:   u.CreatedAt,
+		UpdatedAt:   u.UpdatedAt,
+		IsChirpyRed: u.IsChirpyRed,
+		Username:    u.Username,
+		AvatarUrl:   u.AvatarUrl,
+		Bio:         u.Bio,
+	}, nil
+}
+
+func (f *fakeDB) UpdateUserPassword(ctx context.Context, arg database.UpdateUserPasswordParams) error {
+	u, ok := f.users[arg.ID]
+	if !ok {
+		return nil
+	}
+	u.HashedPassword = arg.HashedPassword
+	u.UpdatedAt = f.tick()
+	f.users[u.ID] = u
+	return nil
+}
+
+func (f *fakeDB) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) (int64, error) {
+	return f.setChirpyRed(id, true), nil
+}
+
+// setChirpyRed updates a user's membership, returning the rows affected.
+func (f *fakeDB) setChirpyRed(id uuid.UUID, red bool) int64 {
+	u, ok := f.users[id]
+	if !ok {
+		return 0
+	}
+	u.IsChirpyRed = red
+	u.UpdatedAt = f.tick()
+	f.users[id] = u
+	return 1
+}
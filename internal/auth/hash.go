@@ -2,8 +2,26 @@ package auth
 
 import "github.com/alexedwards/argon2id"
 
+const (
+	MinPasswordCost = 1
+	MaxPasswordCost = 10
+)
+
+// passwordParams are the argon2id parameters used for new hashes. Existing
+// hashes keep verifying after a change because each hash records its own
+// parameters.
+var passwordParams = *argon2id.DefaultParams
+
+// SetPasswordCost sets the argon2id iteration count used by HashPassword,
+// clamped to [MinPasswordCost, MaxPasswordCost].
+func SetPasswordCost(cost int) {
+	cost = max(MinPasswordCost, min(cost, MaxPasswordCost))
+	passwordParams.Iterations = uint32(cost)
+}
+
 func HashPassword(password string) (string, error) {
-	return argon2id.CreateHash(password, argon2id.DefaultParams)
+	params := passwordParams
+	return argon2id.CreateHash(password, &params)
 }
 
 func CheckPasswordHash(password, hash string) (bool, error) {
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+)
+
+func TestPasswordCost(t *testing.T) {
+	defer SetPasswordCost(int(argon2id.DefaultParams.Iterations))
+
+	tests := []struct {
+		name string
+		cost int
+		want uint32
+	}{
+		{"default", int(argon2id.DefaultParams.Iterations), argon2id.DefaultParams.Iterations},
+		{"higher", 3, 3},
+		{"below range", 0, MinPasswordCost},
+		{"above range", 50, MaxPasswordCost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetPasswordCost(tt.cost)
+			hash, err := HashPassword("correct horse")
+			if err != nil {
+				t.Fatalf("HashPassword failed: %v", err)
+			}
+			params, _, _, err := argon2id.DecodeHash(hash)
+			if err != nil {
+				t.Fatalf("DecodeHash failed: %v", err)
+			}
+			if params.Iterations != tt.want {
+				t.Fatalf("expected %d iterations, got %d", tt.want, params.Iterations)
+			}
+			ok, err := CheckPasswordHash("correct horse", hash)
+			if err != nil || !ok {
+				t.Fatalf("expected password to round-trip, got ok=%v err=%v", ok, err)
+			}
+		})
+	}
+}
@@ -3,19 +3,31 @@ package auth
 import (
 	"time"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"strings"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+// Audience identifies tokens minted for the Chirpy API; ValidateJWT rejects
+// tokens issued for any other audience.
+const Audience = "chirpy-api"
+
+// Leeway is how far ValidateJWT lets the exp, nbf and iat claims miss,
+// so small clock drift between servers doesn't reject good tokens.
+const Leeway = 30 * time.Second
+
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
 	now := time.Now().UTC()
 
 	claims := jwt.RegisteredClaims{
 		Issuer:    "chirpy",
+		Audience:  jwt.ClaimStrings{Audience},
 		IssuedAt:  jwt.NewNumericDate(now),
 		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
 		Subject:   userID.String(),
@@ -33,11 +45,18 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 		tokenString,
 		claims,
 		func(token *jwt.Token) (interface{}, error) {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, errors.New("unexpected signing method")
+			}
 			return []byte(tokenSecret), nil
 		},
 		jwt.WithValidMethods([]string{
 			jwt.SigningMethodHS256.Alg(),
 		}),
+		jwt.WithIssuer("chirpy"),
+		jwt.WithAudience(Audience),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(Leeway),
 	)
 	if err != nil {
 		return uuid.Nil, err
@@ -67,10 +86,24 @@ func GetBearerToken(headers http.Header) (string, error) {
 	return token, nil
 }
 
+// RefreshTokenBytes is the amount of randomness in a refresh token.
+const RefreshTokenBytes = 32
+
+// randReader is the entropy source for refresh tokens; tests replace it.
+var randReader io.Reader = rand.Reader
+
+// MakeRefreshToken returns RefreshTokenBytes bytes from crypto/rand,
+// hex-encoded. It fails rather than return a short token if the read does.
 func MakeRefreshToken() (string, error) {
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+	b := make([]byte, RefreshTokenBytes)
+	if _, err := io.ReadFull(randReader, b); err != nil {
+		return "", fmt.Errorf("reading random bytes: %w", err)
 	}
 	return hex.EncodeToString(b), nil
 }
+
+// HashToken returns the hex SHA-256 of token, the form stored in the DB.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
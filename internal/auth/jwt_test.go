@@ -1,9 +1,15 @@
 package auth
 
 import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing/iotest"
 	"testing"
 	"time"
-	"net/http"	
+	"net/http"
+	"strings"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -37,6 +43,51 @@ func TestExpiredJWT(t *testing.T) {
 	}
 }
 
+func TestJWTLeeway(t *testing.T) {
+	secret := "super-secret"
+	userID := uuid.New()
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name      string
+		issuedAt  time.Time
+		expiresAt time.Time
+		wantErr   bool
+	}{
+		{"expired 10s ago", now.Add(-time.Hour), now.Add(-10 * time.Second), false},
+		{"expired 5m ago", now.Add(-time.Hour), now.Add(-5 * time.Minute), true},
+		{"issued 10s ahead", now.Add(10 * time.Second), now.Add(time.Hour), false},
+		{"issued 5m ahead", now.Add(5 * time.Minute), now.Add(time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.RegisteredClaims{
+				Issuer:    "chirpy",
+				Audience:  jwt.ClaimStrings{Audience},
+				IssuedAt:  jwt.NewNumericDate(tt.issuedAt),
+				ExpiresAt: jwt.NewNumericDate(tt.expiresAt),
+				Subject:   userID.String(),
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+			if err != nil {
+				t.Fatalf("failed to sign token: %v", err)
+			}
+
+			parsedID, err := ValidateJWT(token, secret)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error outside the %v leeway", Leeway)
+				}
+				return
+			}
+			if err != nil || parsedID != userID {
+				t.Fatalf("expected %v within the leeway, got %v (%v)", userID, parsedID, err)
+			}
+		})
+	}
+}
+
 func TestJWTWrongSecret(t *testing.T) {
 	userID := uuid.New()
 
@@ -62,3 +113,187 @@ func TestGetBearerToken(t *testing.T) {
 		t.Fatalf("expected abc123, got %s", token)
 	}
 }
+
+func TestJWTSameSecretValidates(t *testing.T) {
+	userID := uuid.New()
+
+	token, err := MakeJWT(userID, "secret-a", time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	parsedID, err := ValidateJWT(token, "secret-a")
+	if err != nil {
+		t.Fatalf("expected token signed with secret-a to validate, got %v", err)
+	}
+	if parsedID != userID {
+		t.Errorf("expected userID %v, got %v", userID, parsedID)
+	}
+
+	if _, err := ValidateJWT(token, "secret-b"); err == nil {
+		t.Fatalf("expected token signed with secret-a to fail against secret-b")
+	}
+}
+
+func TestJWTTamperedSignature(t *testing.T) {
+	secret := "super-secret"
+
+	token, err := MakeJWT(uuid.New(), secret, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	sigStart := strings.LastIndex(token, ".") + 1
+	replacement := byte('A')
+	if token[sigStart] == 'A' {
+		replacement = 'B'
+	}
+	tampered := token[:sigStart] + string(replacement) + token[sigStart+1:]
+
+	if _, err := ValidateJWT(tampered, secret); err == nil {
+		t.Fatalf("expected error for tampered signature")
+	}
+}
+
+func TestJWTForeignIssuer(t *testing.T) {
+	secret := "super-secret"
+	now := time.Now().UTC()
+
+	claims := jwt.RegisteredClaims{
+		Issuer:    "not-chirpy",
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		Subject:   uuid.New().String(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	if _, err := ValidateJWT(token, secret); err == nil {
+		t.Fatalf("expected error for foreign issuer")
+	}
+}
+
+func TestJWTAudience(t *testing.T) {
+	secret := "super-secret"
+	userID := uuid.New()
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name     string
+		audience jwt.ClaimStrings
+		wantErr  bool
+	}{
+		{"matching audience", jwt.ClaimStrings{Audience}, false},
+		{"one of several audiences", jwt.ClaimStrings{"chirpy-admin", Audience}, false},
+		{"other audience", jwt.ClaimStrings{"chirpy-admin"}, true},
+		{"no audience", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.RegisteredClaims{
+				Issuer:    "chirpy",
+				Audience:  tt.audience,
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+				Subject:   userID.String(),
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+			if err != nil {
+				t.Fatalf("failed to sign token: %v", err)
+			}
+
+			parsedID, err := ValidateJWT(token, secret)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for audience %v", tt.audience)
+				}
+				return
+			}
+			if err != nil || parsedID != userID {
+				t.Fatalf("expected %v, got %v (%v)", userID, parsedID, err)
+			}
+		})
+	}
+}
+
+func TestJWTRejectsOtherSigningMethods(t *testing.T) {
+	secret := "super-secret"
+	now := time.Now().UTC()
+	claims := jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		Audience:  jwt.ClaimStrings{Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		Subject:   uuid.New().String(),
+	}
+
+	noneToken, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("failed to sign none token: %v", err)
+	}
+	if _, err := ValidateJWT(noneToken, secret); err == nil {
+		t.Fatalf("expected error for none-signed token")
+	}
+
+	hs512Token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("failed to sign HS512 token: %v", err)
+	}
+	if _, err := ValidateJWT(hs512Token, secret); err == nil {
+		t.Fatalf("expected error for HS512-signed token")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	raw, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken failed: %v", err)
+	}
+	hashed := HashToken(raw)
+	if hashed == raw {
+		t.Fatalf("expected hash to differ from raw token")
+	}
+	if HashToken(raw) != hashed {
+		t.Fatalf("expected hashing to be deterministic")
+	}
+	if len(hashed) != 64 {
+		t.Fatalf("expected 64 hex chars, got %d", len(hashed))
+	}
+}
+
+func TestMakeRefreshToken(t *testing.T) {
+	first, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken failed: %v", err)
+	}
+	second, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken failed: %v", err)
+	}
+	if len(first) != 2*RefreshTokenBytes {
+		t.Fatalf("expected %d hex chars, got %d", 2*RefreshTokenBytes, len(first))
+	}
+	if _, err := hex.DecodeString(first); err != nil {
+		t.Fatalf("expected hex token, got %q", first)
+	}
+	if first == second {
+		t.Fatalf("expected two tokens to differ")
+	}
+}
+
+func TestMakeRefreshTokenRandFailure(t *testing.T) {
+	defer func(r io.Reader) { randReader = r }(randReader)
+
+	for name, r := range map[string]io.Reader{
+		"read error": iotest.ErrReader(errors.New("entropy exhausted")),
+		"short read": strings.NewReader("too short"),
+	} {
+		randReader = r
+		if token, err := MakeRefreshToken(); err == nil {
+			t.Errorf("%s: expected an error, got token %q", name, token)
+		}
+	}
+}
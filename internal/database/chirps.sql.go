@@ -7,23 +7,66 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const countChirps = `-- name: CountChirps :one
+SELECT COUNT(*) FROM chirps WHERE deleted_at IS NULL
+`
+
+func (q *Queries) CountChirps(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirps)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countChirpsByAuthor = `-- name: CountChirpsByAuthor :one
+SELECT COUNT(*) FROM chirps WHERE user_id = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) CountChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsByAuthor, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserChirpsSince = `-- name: CountUserChirpsSince :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1 AND created_at > $2
+`
+
+type CountUserChirpsSinceParams struct {
+	UserID uuid.UUID
+	Since  time.Time
+}
+
+// Deleted chirps still count, so deleting doesn't refill the rate limit.
+func (q *Queries) CountUserChirpsSince(ctx context.Context, arg CountUserChirpsSinceParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUserChirpsSince, arg.UserID, arg.Since)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (body, user_id)
-VALUES ($1, $2)
-RETURNING id, created_at, updated_at, body, user_id
+INSERT INTO chirps (body, user_id, parent_id)
+VALUES ($1, $2, $3)
+RETURNING id, created_at, updated_at, body, user_id, parent_id, deleted_at
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body     string
+	UserID   uuid.UUID
+	ParentID uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID, arg.ParentID)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -31,24 +74,25 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ParentID,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const deleteChirp = `-- name: DeleteChirp :exec
+const deleteAllChirps = `-- name: DeleteAllChirps :exec
 DELETE FROM chirps
-WHERE id = $1
 `
 
-func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteChirp, id)
+func (q *Queries) DeleteAllChirps(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteAllChirps)
 	return err
 }
 
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, parent_id, deleted_at
 FROM chirps
-WHERE id = $1
+WHERE id = $1 AND deleted_at IS NULL
 `
 
 func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -60,31 +104,42 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ParentID,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id
+const getChirpReplies = `-- name: GetChirpReplies :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.parent_id, chirps.deleted_at, (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
 FROM chirps
+WHERE parent_id = $1 AND deleted_at IS NULL
 ORDER BY created_at ASC
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+type GetChirpRepliesRow struct {
+	Chirp Chirp
+	Likes int64
+}
+
+func (q *Queries) GetChirpReplies(ctx context.Context, parentID uuid.NullUUID) ([]GetChirpRepliesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpReplies, parentID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Chirp
+	var items []GetChirpRepliesRow
 	for rows.Next() {
-		var i Chirp
+		var i GetChirpRepliesRow
 		if err := rows.Scan(
-			&i.ID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Body,
-			&i.UserID,
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentID,
+			&i.Chirp.DeletedAt,
+			&i.Likes,
 		); err != nil {
 			return nil, err
 		}
@@ -99,28 +154,326 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id
+const getChirpsAfter = `-- name: GetChirpsAfter :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.parent_id, chirps.deleted_at, (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
 FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC
+WHERE (created_at, id) > ($1::timestamp, $2::uuid)
+  AND ($3::uuid IS NULL OR user_id = $3)
+  AND deleted_at IS NULL
+ORDER BY created_at ASC, id ASC
+LIMIT $4
 `
 
-func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, userID)
+type GetChirpsAfterParams struct {
+	CursorCreatedAt time.Time
+	CursorID        uuid.UUID
+	AuthorID        uuid.NullUUID
+	RowLimit        int32
+}
+
+type GetChirpsAfterRow struct {
+	Chirp Chirp
+	Likes int64
+}
+
+func (q *Queries) GetChirpsAfter(ctx context.Context, arg GetChirpsAfterParams) ([]GetChirpsAfterRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsAfter,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.AuthorID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpsAfterRow
+	for rows.Next() {
+		var i GetChirpsAfterRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentID,
+			&i.Chirp.DeletedAt,
+			&i.Likes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsBefore = `-- name: GetChirpsBefore :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.parent_id, chirps.deleted_at, (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
+FROM chirps
+WHERE ($1::timestamp IS NULL
+    OR (created_at, id) < ($1::timestamp, $2::uuid))
+  AND ($3::uuid IS NULL OR user_id = $3)
+  AND deleted_at IS NULL
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type GetChirpsBeforeParams struct {
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	AuthorID        uuid.NullUUID
+	RowLimit        int32
+}
+
+type GetChirpsBeforeRow struct {
+	Chirp Chirp
+	Likes int64
+}
+
+func (q *Queries) GetChirpsBefore(ctx context.Context, arg GetChirpsBeforeParams) ([]GetChirpsBeforeRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsBefore,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.AuthorID,
+		arg.RowLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Chirp
+	var items []GetChirpsBeforeRow
 	for rows.Next() {
-		var i Chirp
+		var i GetChirpsBeforeRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentID,
+			&i.Chirp.DeletedAt,
+			&i.Likes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsPaged = `-- name: GetChirpsPaged :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.parent_id, chirps.deleted_at, (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
+FROM chirps
+WHERE deleted_at IS NULL
+  AND ($1::uuid IS NULL OR user_id = $1)
+  AND ($2::timestamp IS NULL OR created_at >= $2)
+  AND ($3::timestamp IS NULL OR created_at < $3)
+ORDER BY
+    CASE WHEN $4::bool THEN created_at END DESC,
+    created_at ASC
+LIMIT $5 OFFSET $6
+`
+
+type GetChirpsPagedParams struct {
+	AuthorID  uuid.NullUUID
+	Start     sql.NullTime
+	End       sql.NullTime
+	SortDesc  bool
+	RowLimit  int32
+	RowOffset int32
+}
+
+type GetChirpsPagedRow struct {
+	Chirp Chirp
+	Likes int64
+}
+
+func (q *Queries) GetChirpsPaged(ctx context.Context, arg GetChirpsPagedParams) ([]GetChirpsPagedRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsPaged,
+		arg.AuthorID,
+		arg.Start,
+		arg.End,
+		arg.SortDesc,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpsPagedRow
+	for rows.Next() {
+		var i GetChirpsPagedRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentID,
+			&i.Chirp.DeletedAt,
+			&i.Likes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsWithAuthor = `-- name: GetChirpsWithAuthor :many
+SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.parent_id, c.deleted_at, u.email AS author_email,
+       (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = c.id) AS likes
+FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE c.deleted_at IS NULL
+  AND ($1::uuid IS NULL OR c.user_id = $1)
+  AND ($2::timestamp IS NULL OR c.created_at >= $2)
+  AND ($3::timestamp IS NULL OR c.created_at < $3)
+ORDER BY
+    CASE WHEN $4::bool THEN c.created_at END DESC,
+    c.created_at ASC
+LIMIT $5 OFFSET $6
+`
+
+type GetChirpsWithAuthorParams struct {
+	AuthorID  uuid.NullUUID
+	Start     sql.NullTime
+	End       sql.NullTime
+	SortDesc  bool
+	RowLimit  int32
+	RowOffset int32
+}
+
+type GetChirpsWithAuthorRow struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Body        string
+	UserID      uuid.UUID
+	ParentID    uuid.NullUUID
+	DeletedAt   sql.NullTime
+	AuthorEmail string
+	Likes       int64
+}
+
+func (q *Queries) GetChirpsWithAuthor(ctx context.Context, arg GetChirpsWithAuthorParams) ([]GetChirpsWithAuthorRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsWithAuthor,
+		arg.AuthorID,
+		arg.Start,
+		arg.End,
+		arg.SortDesc,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpsWithAuthorRow
+	for rows.Next() {
+		var i GetChirpsWithAuthorRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ParentID,
+			&i.DeletedAt,
+			&i.AuthorEmail,
+			&i.Likes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeletedChirp = `-- name: GetDeletedChirp :one
+SELECT id, created_at, updated_at, body, user_id, parent_id, deleted_at
+FROM chirps
+WHERE id = $1 AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) GetDeletedChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getDeletedChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ParentID,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const getFeed = `-- name: GetFeed :many
+SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.parent_id, c.deleted_at, (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = c.id) AS likes
+FROM chirps c
+JOIN follows f ON f.followee_id = c.user_id
+WHERE f.follower_id = $1 AND c.deleted_at IS NULL
+ORDER BY c.created_at DESC
+LIMIT $2 OFFSET $3
+`
+
+type GetFeedParams struct {
+	FollowerID uuid.UUID
+	Limit      int32
+	Offset     int32
+}
+
+type GetFeedRow struct {
+	Chirp Chirp
+	Likes int64
+}
+
+func (q *Queries) GetFeed(ctx context.Context, arg GetFeedParams) ([]GetFeedRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFeed, arg.FollowerID, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFeedRow
+	for rows.Next() {
+		var i GetFeedRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentID,
+			&i.Chirp.DeletedAt,
+			&i.Likes,
 		); err != nil {
 			return nil, err
 		}
@@ -134,3 +487,159 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const purgeDeletedChirps = `-- name: PurgeDeletedChirps :execrows
+DELETE FROM chirps
+WHERE deleted_at < $1
+`
+
+func (q *Queries) PurgeDeletedChirps(ctx context.Context, deletedAt sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedChirps, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreChirp = `-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING id, created_at, updated_at, body, user_id, parent_id, deleted_at
+`
+
+func (q *Queries) RestoreChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, restoreChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ParentID,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.parent_id, chirps.deleted_at, (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
+FROM chirps
+WHERE body ILIKE '%' || $1::text || '%' ESCAPE '\'
+  AND deleted_at IS NULL
+  AND ($2::uuid IS NULL OR user_id = $2)
+  AND ($3::timestamp IS NULL OR created_at >= $3)
+  AND ($4::timestamp IS NULL OR created_at < $4)
+ORDER BY
+    CASE WHEN $5::bool THEN created_at END DESC,
+    created_at ASC
+LIMIT $6 OFFSET $7
+`
+
+type SearchChirpsParams struct {
+	Query     string
+	AuthorID  uuid.NullUUID
+	Start     sql.NullTime
+	End       sql.NullTime
+	SortDesc  bool
+	RowLimit  int32
+	RowOffset int32
+}
+
+type SearchChirpsRow struct {
+	Chirp Chirp
+	Likes int64
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.Start,
+		arg.End,
+		arg.SortDesc,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentID,
+			&i.Chirp.DeletedAt,
+			&i.Likes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const softDeleteChirp = `-- name: SoftDeleteChirp :exec
+UPDATE chirps
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) SoftDeleteChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, softDeleteChirp, id)
+	return err
+}
+
+const softDeleteChirpsByAuthor = `-- name: SoftDeleteChirpsByAuthor :execrows
+UPDATE chirps
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) SoftDeleteChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, softDeleteChirpsByAuthor, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateChirp = `-- name: UpdateChirp :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, parent_id, deleted_at
+`
+
+type UpdateChirpParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirp(ctx context.Context, arg UpdateChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirp, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ParentID,
+		&i.DeletedAt,
+	)
+	return i, err
+}
@@ -17,6 +17,50 @@ type Chirp struct {
 	UpdatedAt time.Time
 	Body      string
 	UserID    uuid.UUID
+	ParentID  uuid.NullUUID
+	DeletedAt sql.NullTime
+}
+
+type ChirpLike struct {
+	ChirpID   uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type ChirpMention struct {
+	ChirpID uuid.UUID
+	UserID  uuid.UUID
+}
+
+type ChirpTag struct {
+	ChirpID uuid.UUID
+	Tag     string
+}
+
+type EmailVerificationToken struct {
+	Token     string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type PasswordResetToken struct {
+	Token     string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type ProcessedWebhook struct {
+	EventID     string
+	ProcessedAt time.Time
 }
 
 type RefreshToken struct {
@@ -35,4 +79,8 @@ type User struct {
 	Email          string
 	HashedPassword string
 	IsChirpyRed    bool
+	IsVerified     bool
+	Username       sql.NullString
+	AvatarUrl      string
+	Bio            string
 }
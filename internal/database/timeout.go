@@ -0,0 +1,401 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WithTimeout wraps q so every query runs under its own deadline of d,
+// letting a stuck database fail the query instead of hanging the caller.
+// A query cut off by that deadline returns context.DeadlineExceeded.
+//
+// Unlike the rest of this package, this file is written by hand: every
+// query added to Querier needs a method here too, which the compiler
+// enforces.
+func WithTimeout(q Querier, d time.Duration) Querier {
+	return timeoutQuerier{next: q, timeout: d}
+}
+
+type timeoutQuerier struct {
+	next    Querier
+	timeout time.Duration
+}
+
+// timeoutErr reports context.DeadlineExceeded in place of whatever error
+// the driver produced once ctx has expired, so callers can match it.
+func timeoutErr(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (q timeoutQuerier) ConsumePasswordResetToken(ctx context.Context, token string) (uuid.UUID, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.ConsumePasswordResetToken(ctx, token)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) ConsumeVerificationToken(ctx context.Context, token string) (uuid.UUID, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.ConsumeVerificationToken(ctx, token)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) CountChirps(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.CountChirps(ctx)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) CountChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.CountChirpsByAuthor(ctx, userID)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) CountLikes(ctx context.Context, chirpID uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.CountLikes(ctx, chirpID)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) CountUserChirpsSince(ctx context.Context, arg CountUserChirpsSinceParams) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.CountUserChirpsSince(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) CountUsers(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.CountUsers(ctx)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.CreateChirp(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) CreateChirpMention(ctx context.Context, arg CreateChirpMentionParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.CreateChirpMention(ctx, arg))
+}
+
+func (q timeoutQuerier) CreateChirpTag(ctx context.Context, arg CreateChirpTagParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.CreateChirpTag(ctx, arg))
+}
+
+func (q timeoutQuerier) CreateFollow(ctx context.Context, arg CreateFollowParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.CreateFollow(ctx, arg))
+}
+
+func (q timeoutQuerier) CreateLike(ctx context.Context, arg CreateLikeParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.CreateLike(ctx, arg))
+}
+
+func (q timeoutQuerier) CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.CreatePasswordResetToken(ctx, arg))
+}
+
+func (q timeoutQuerier) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.CreateRefreshToken(ctx, arg))
+}
+
+func (q timeoutQuerier) CreateUserWithPassword(ctx context.Context, arg CreateUserWithPasswordParams) (CreateUserWithPasswordRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.CreateUserWithPassword(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) CreateVerificationToken(ctx context.Context, arg CreateVerificationTokenParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.CreateVerificationToken(ctx, arg))
+}
+
+func (q timeoutQuerier) DeleteAllChirps(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.DeleteAllChirps(ctx))
+}
+
+func (q timeoutQuerier) DeleteAllUsers(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.DeleteAllUsers(ctx))
+}
+
+func (q timeoutQuerier) DeleteChirpMentions(ctx context.Context, chirpID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.DeleteChirpMentions(ctx, chirpID))
+}
+
+func (q timeoutQuerier) DeleteChirpTags(ctx context.Context, chirpID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.DeleteChirpTags(ctx, chirpID))
+}
+
+func (q timeoutQuerier) DeleteFollow(ctx context.Context, arg DeleteFollowParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.DeleteFollow(ctx, arg))
+}
+
+func (q timeoutQuerier) DeleteLike(ctx context.Context, arg DeleteLikeParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.DeleteLike(ctx, arg))
+}
+
+func (q timeoutQuerier) DeleteProcessedWebhook(ctx context.Context, eventID string) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.DeleteProcessedWebhook(ctx, eventID))
+}
+
+func (q timeoutQuerier) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.DeleteUser(ctx, id)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) DowngradeUserFromChirpyRed(ctx context.Context, id uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.DowngradeUserFromChirpyRed(ctx, id)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetActiveRefreshTokensForUser(ctx context.Context, userID uuid.NullUUID) ([]RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetActiveRefreshTokensForUser(ctx, userID)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetChirp(ctx, id)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetChirpReplies(ctx context.Context, parentID uuid.NullUUID) ([]GetChirpRepliesRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetChirpReplies(ctx, parentID)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetChirpsAfter(ctx context.Context, arg GetChirpsAfterParams) ([]GetChirpsAfterRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetChirpsAfter(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetChirpsBefore(ctx context.Context, arg GetChirpsBeforeParams) ([]GetChirpsBeforeRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetChirpsBefore(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetChirpsByTag(ctx context.Context, arg GetChirpsByTagParams) ([]GetChirpsByTagRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetChirpsByTag(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetChirpsMentioningUser(ctx context.Context, arg GetChirpsMentioningUserParams) ([]GetChirpsMentioningUserRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetChirpsMentioningUser(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetChirpsPaged(ctx context.Context, arg GetChirpsPagedParams) ([]GetChirpsPagedRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetChirpsPaged(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetChirpsWithAuthor(ctx context.Context, arg GetChirpsWithAuthorParams) ([]GetChirpsWithAuthorRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetChirpsWithAuthor(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetDeletedChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetDeletedChirp(ctx, id)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetFeed(ctx context.Context, arg GetFeedParams) ([]GetFeedRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetFeed(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetRefreshToken(ctx, token)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetUserByEmail(ctx context.Context, email string) (GetUserByEmailRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetUserByEmail(ctx, email)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetUserByID(ctx, id)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetUserByUsername(ctx context.Context, username string) (GetUserByUsernameRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetUserByUsername(ctx, username)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) GetUserFromValidRefreshToken(ctx context.Context, token string) (GetUserFromValidRefreshTokenRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.GetUserFromValidRefreshToken(ctx, token)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) MarkUserVerified(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.MarkUserVerified(ctx, id))
+}
+
+func (q timeoutQuerier) PurgeDeletedChirps(ctx context.Context, deletedAt sql.NullTime) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.PurgeDeletedChirps(ctx, deletedAt)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) RecordProcessedWebhook(ctx context.Context, eventID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.RecordProcessedWebhook(ctx, eventID)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) RestoreChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.RestoreChirp(ctx, id)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) RevokeActiveRefreshToken(ctx context.Context, token string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.RevokeActiveRefreshToken(ctx, token)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) RevokeAllRefreshTokensForUser(ctx context.Context, userID uuid.NullUUID) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.RevokeAllRefreshTokensForUser(ctx, userID))
+}
+
+func (q timeoutQuerier) RevokeRefreshToken(ctx context.Context, arg RevokeRefreshTokenParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.RevokeRefreshToken(ctx, arg))
+}
+
+func (q timeoutQuerier) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.SearchChirps(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) SoftDeleteChirp(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.SoftDeleteChirp(ctx, id))
+}
+
+func (q timeoutQuerier) SoftDeleteChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.SoftDeleteChirpsByAuthor(ctx, userID)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) UpdateChirp(ctx context.Context, arg UpdateChirpParams) (Chirp, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.UpdateChirp(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateUserRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.UpdateUser(ctx, arg)
+	return v, timeoutErr(ctx, err)
+}
+
+func (q timeoutQuerier) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return timeoutErr(ctx, q.next.UpdateUserPassword(ctx, arg))
+}
+
+func (q timeoutQuerier) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	v, err := q.next.UpgradeUserToChirpyRed(ctx, id)
+	return v, timeoutErr(ctx, err)
+}
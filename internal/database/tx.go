@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// CreateChirpsTx inserts every chirp in params inside one transaction on
+// db, so either all of them are created or none are. Like the timeout
+// wrapper, it reports context.DeadlineExceeded once ctx has expired.
+func CreateChirpsTx(ctx context.Context, db *sql.DB, params []CreateChirpParams) ([]Chirp, error) {
+	chirps, err := createChirpsTx(ctx, db, params)
+	return chirps, timeoutErr(ctx, err)
+}
+
+func createChirpsTx(ctx context.Context, db *sql.DB, params []CreateChirpParams) ([]Chirp, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q := New(db).WithTx(tx)
+	chirps := make([]Chirp, 0, len(params))
+	for _, p := range params {
+		chirp, err := q.CreateChirp(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return chirps, nil
+}
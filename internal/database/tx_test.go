@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stallDriver opens connections whose queries block until their context
+// ends and then fail the way pq does, with an error of their own rather
+// than the context's.
+type stallDriver struct{}
+
+func (stallDriver) Open(string) (driver.Conn, error) { return stallConn{}, nil }
+
+type stallConn struct{}
+
+func (stallConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (stallConn) Close() error                        { return nil }
+func (stallConn) Begin() (driver.Tx, error)           { return stallTx{}, nil }
+
+func (stallConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, errors.New("pq: canceling statement due to user request")
+}
+
+type stallTx struct{}
+
+func (stallTx) Commit() error   { return nil }
+func (stallTx) Rollback() error { return nil }
+
+func init() {
+	sql.Register("stall", stallDriver{})
+}
+
+func TestCreateChirpsTxTimeout(t *testing.T) {
+	db, err := sql.Open("stall", "")
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = CreateChirpsTx(ctx, db, []CreateChirpParams{{Body: "too slow"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -7,51 +7,40 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email)
-VALUES (
-    gen_random_uuid(),
-    NOW(),
-    NOW(),
-    $1
-)
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+const countUsers = `-- name: CountUsers :one
+SELECT COUNT(*) FROM users
 `
 
-func (q *Queries) CreateUser(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, email)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.Email,
-		&i.HashedPassword,
-		&i.IsChirpyRed,
-	)
-	return i, err
+func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
 }
 
 const createUserWithPassword = `-- name: CreateUserWithPassword :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, username)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
-RETURNING id, created_at, updated_at, email, is_chirpy_red
+RETURNING id, created_at, updated_at, email, is_chirpy_red, username, avatar_url, bio
 `
 
 type CreateUserWithPasswordParams struct {
 	Email          string
 	HashedPassword string
+	Username       sql.NullString
 }
 
 type CreateUserWithPasswordRow struct {
@@ -60,10 +49,13 @@ type CreateUserWithPasswordRow struct {
 	UpdatedAt   time.Time
 	Email       string
 	IsChirpyRed bool
+	Username    sql.NullString
+	AvatarUrl   string
+	Bio         string
 }
 
 func (q *Queries) CreateUserWithPassword(ctx context.Context, arg CreateUserWithPasswordParams) (CreateUserWithPasswordRow, error) {
-	row := q.db.QueryRowContext(ctx, createUserWithPassword, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUserWithPassword, arg.Email, arg.HashedPassword, arg.Username)
 	var i CreateUserWithPasswordRow
 	err := row.Scan(
 		&i.ID,
@@ -71,6 +63,9 @@ func (q *Queries) CreateUserWithPassword(ctx context.Context, arg CreateUserWith
 		&i.UpdatedAt,
 		&i.Email,
 		&i.IsChirpyRed,
+		&i.Username,
+		&i.AvatarUrl,
+		&i.Bio,
 	)
 	return i, err
 }
@@ -84,10 +79,37 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const downgradeUserFromChirpyRed = `-- name: DowngradeUserFromChirpyRed :execrows
+UPDATE users
+SET is_chirpy_red = FALSE, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) DowngradeUserFromChirpyRed(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, downgradeUserFromChirpyRed, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red
+SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red, username, avatar_url, bio
 FROM users
-WHERE email = $1
+WHERE lower(email) = lower($1)
 `
 
 type GetUserByEmailRow struct {
@@ -97,6 +119,9 @@ type GetUserByEmailRow struct {
 	UpdatedAt      time.Time
 	HashedPassword string
 	IsChirpyRed    bool
+	Username       sql.NullString
+	AvatarUrl      string
+	Bio            string
 }
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (GetUserByEmailRow, error) {
@@ -109,23 +134,111 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (GetUserByEm
 		&i.UpdatedAt,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Username,
+		&i.AvatarUrl,
+		&i.Bio,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, email, created_at, updated_at, is_chirpy_red, is_verified, username, avatar_url, bio
+FROM users
+WHERE id = $1
+`
+
+type GetUserByIDRow struct {
+	ID          uuid.UUID
+	Email       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	IsChirpyRed bool
+	IsVerified  bool
+	Username    sql.NullString
+	AvatarUrl   string
+	Bio         string
+}
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i GetUserByIDRow
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.IsChirpyRed,
+		&i.IsVerified,
+		&i.Username,
+		&i.AvatarUrl,
+		&i.Bio,
 	)
 	return i, err
 }
 
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red, username, avatar_url, bio
+FROM users
+WHERE lower(username) = lower($1)
+`
+
+type GetUserByUsernameRow struct {
+	ID             uuid.UUID
+	Email          string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	HashedPassword string
+	IsChirpyRed    bool
+	Username       sql.NullString
+	AvatarUrl      string
+	Bio            string
+}
+
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (GetUserByUsernameRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserByUsername, username)
+	var i GetUserByUsernameRow
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Username,
+		&i.AvatarUrl,
+		&i.Bio,
+	)
+	return i, err
+}
+
+const markUserVerified = `-- name: MarkUserVerified :exec
+UPDATE users
+SET is_verified = TRUE, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) MarkUserVerified(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, markUserVerified, id)
+	return err
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
-SET email = $2,
-    hashed_password = $3,
+SET email = COALESCE($1, email),
+    hashed_password = COALESCE($2, hashed_password),
+    avatar_url = COALESCE($3, avatar_url),
+    bio = COALESCE($4, bio),
     updated_at = NOW()
-WHERE id = $1
-RETURNING id, email, created_at, updated_at, is_chirpy_red
+WHERE id = $5
+RETURNING id, email, created_at, updated_at, is_chirpy_red, username, avatar_url, bio
 `
 
 type UpdateUserParams struct {
+	Email          sql.NullString
+	HashedPassword sql.NullString
+	AvatarUrl      sql.NullString
+	Bio            sql.NullString
 	ID             uuid.UUID
-	Email          string
-	HashedPassword string
 }
 
 type UpdateUserRow struct {
@@ -134,10 +247,19 @@ type UpdateUserRow struct {
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	IsChirpyRed bool
+	Username    sql.NullString
+	AvatarUrl   string
+	Bio         string
 }
 
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateUserRow, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.ID, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, updateUser,
+		arg.Email,
+		arg.HashedPassword,
+		arg.AvatarUrl,
+		arg.Bio,
+		arg.ID,
+	)
 	var i UpdateUserRow
 	err := row.Scan(
 		&i.ID,
@@ -145,17 +267,40 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateU
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.IsChirpyRed,
+		&i.Username,
+		&i.AvatarUrl,
+		&i.Bio,
 	)
 	return i, err
 }
 
-const upgradeUserToChirpyRed = `-- name: UpgradeUserToChirpyRed :exec
+const updateUserPassword = `-- name: UpdateUserPassword :exec
 UPDATE users
-SET is_chirpy_red = TRUE, updated_at = NOW()
+SET hashed_password = $2,
+    updated_at = NOW()
 WHERE id = $1
 `
 
-func (q *Queries) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, upgradeUserToChirpyRed, id)
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
 	return err
 }
+
+const upgradeUserToChirpyRed = `-- name: UpgradeUserToChirpyRed :execrows
+UPDATE users
+SET is_chirpy_red = TRUE, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, upgradeUserToChirpyRed, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
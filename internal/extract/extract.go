@@ -0,0 +1,65 @@
+package extract
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Hashtags returns the #hashtags in body, lowercased and without
+// duplicates, in order of first appearance. A tag is a run of letters,
+// digits and underscores after a '#' that doesn't follow another word
+// character, so "#Go." yields "go" while "C#" and "#2" yield nothing.
+func Hashtags(body string) []string {
+	return scan(body, '#', hasLetter)
+}
+
+// Mentions returns the handles @mentioned in body, lowercased and without
+// duplicates, in order of first appearance. A handle is a run of letters,
+// digits and underscores, the characters usernames allow, so "@alice."
+// and "@alice-smith" both yield "alice". An '@' right after a word
+// character starts no mention, which keeps email addresses from counting.
+func Mentions(body string) []string {
+	return scan(body, '@', func(string) bool { return true })
+}
+
+// scan collects the runs of word runes that follow marker where marker
+// doesn't itself follow a word character. Each run is lowercased and kept
+// if non-empty, accepted by keep and not seen before.
+func scan(body string, marker byte, keep func(string) bool) []string {
+	var found []string
+	seen := map[string]bool{}
+	for i := 0; i < len(body); i++ {
+		if body[i] != marker || (i > 0 && isWordRune(lastRune(body[:i]))) {
+			continue
+		}
+		end := i + 1
+		for end < len(body) {
+			r, size := utf8.DecodeRuneInString(body[end:])
+			if !isWordRune(r) {
+				break
+			}
+			end += size
+		}
+		token := strings.ToLower(body[i+1 : end])
+		if token != "" && keep(token) && !seen[token] {
+			seen[token] = true
+			found = append(found, token)
+		}
+		i = end - 1
+	}
+	return found
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func hasLetter(s string) bool {
+	return strings.IndexFunc(s, unicode.IsLetter) >= 0
+}
@@ -0,0 +1,49 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHashtags(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"no tags here", nil},
+		{"#go", []string{"go"}},
+		{"learning #go.", []string{"go"}},
+		{"#Go, #go and #GO!", []string{"go"}},
+		{"(#first)#second", []string{"first", "second"}},
+		{"#two_words #café", []string{"two_words", "café"}},
+		{"C# and email#tag", nil},
+		{"#1 fan of #web3", []string{"web3"}},
+		{"# lonely hash ##double", []string{"double"}},
+	}
+	for _, tt := range tests {
+		if got := Hashtags(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Hashtags(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"nobody here", nil},
+		{"hi @alice", []string{"alice"}},
+		{"thanks @Alice. And @ALICE!", []string{"alice"}},
+		{"@bob,@carol: lunch?", []string{"bob", "carol"}},
+		{"@first.last and @dash-", []string{"first", "dash"}},
+		{"@a-b @c+d @snake_case", []string{"a", "c", "snake_case"}},
+		{"mail me at bob@example.com", nil},
+		{"@ alone and @@double", []string{"double"}},
+	}
+	for _, tt := range tests {
+		if got := Mentions(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Mentions(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
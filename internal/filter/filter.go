@@ -0,0 +1,51 @@
+package filter
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Clean splits body on whitespace and replaces every word found in profane
+// (compared case-insensitively) with "****". Leading and trailing
+// punctuation is ignored for the comparison and kept in the output, so
+// "Sharbert!" becomes "****!". The whitespace itself, including newlines,
+// is kept as written.
+func Clean(body string, profane map[string]bool) string {
+	var b strings.Builder
+	b.Grow(len(body))
+	for body != "" {
+		start := strings.IndexFunc(body, notSpace)
+		if start < 0 {
+			b.WriteString(body)
+			break
+		}
+		b.WriteString(body[:start])
+		body = body[start:]
+
+		end := strings.IndexFunc(body, unicode.IsSpace)
+		if end < 0 {
+			end = len(body)
+		}
+		b.WriteString(cleanWord(body[:end], profane))
+		body = body[end:]
+	}
+	return b.String()
+}
+
+// notSpace reports whether r can be part of a word, i.e. isn't whitespace.
+func notSpace(r rune) bool {
+	return !unicode.IsSpace(r)
+}
+
+// cleanWord censors word if, without its surrounding punctuation, it is
+// in profane.
+func cleanWord(word string, profane map[string]bool) string {
+	trimmed := strings.TrimLeftFunc(word, unicode.IsPunct)
+	prefix := word[:len(word)-len(trimmed)]
+	core := strings.TrimRightFunc(trimmed, unicode.IsPunct)
+	suffix := trimmed[len(core):]
+	if core != "" && profane[strings.ToLower(core)] {
+		return prefix + "****" + suffix
+	}
+	return word
+}
@@ -0,0 +1,43 @@
+package filter
+
+import "testing"
+
+func TestClean(t *testing.T) {
+	profane := map[string]bool{"kerfuffle": true, "sharbert": true, "fornax": true}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty", "", ""},
+		{"clean", "hello world", "hello world"},
+		{"single word", "kerfuffle", "****"},
+		{"start", "kerfuffle at the start", "**** at the start"},
+		{"end", "at the end fornax", "at the end ****"},
+		{"multiple", "kerfuffle and sharbert and fornax", "**** and **** and ****"},
+		{"mixed case", "KerFuffle SHARBERT", "**** ****"},
+		{"trailing exclamation", "Sharbert!", "****!"},
+		{"trailing period", "that was a fornax.", "that was a ****."},
+		{"leading and trailing commas", "well ,kerfuffle, then", "well ,****, then"},
+		{"mixed case with punctuation", "KERFUFFLE?!", "****?!"},
+		{"punctuation only", "!!! ...", "!!! ..."},
+		{"substring untouched", "kerfuffles", "kerfuffles"},
+		{"double spaces kept", "a  fornax", "a  ****"},
+		{"trailing comma", "kerfuffle, then", "****, then"},
+		{"double quotes", `she said "sharbert"`, `she said "****"`},
+		{"single quotes", "'fornax'", "'****'"},
+		{"curly quotes", "“Kerfuffle!”", "“****!”"},
+		{"newline", "hello\nkerfuffle.\nbye", "hello\n****.\nbye"},
+		{"tab", "fornax\tfornax", "****\t****"},
+		{"surrounding whitespace kept", " \n sharbert \t", " \n **** \t"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Clean(tt.body, profane); got != tt.want {
+				t.Errorf("Clean(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Requests counts HTTP requests and their latencies by route and status.
+// It is a prometheus.Collector, so it is exposed by registering it.
+type Requests struct {
+	total    *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewRequests returns an empty Requests using the given histogram upper
+// bounds, which must be sorted ascending.
+func NewRequests(buckets []float64) *Requests {
+	return &Requests{
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_http_requests_total",
+			Help: "Requests handled, by route and status.",
+		}, []string{"route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_http_request_duration_seconds",
+			Help:    "Request latency, by route and status.",
+			Buckets: buckets,
+		}, []string{"route", "status"}),
+	}
+}
+
+// Observe records one request to route that answered status after d.
+func (m *Requests) Observe(route string, status int, d time.Duration) {
+	code := strconv.Itoa(status)
+	m.total.WithLabelValues(route, code).Inc()
+	m.duration.WithLabelValues(route, code).Observe(d.Seconds())
+}
+
+// Reset forgets every recorded request.
+func (m *Requests) Reset() {
+	m.total.Reset()
+	m.duration.Reset()
+}
+
+// Describe implements prometheus.Collector.
+func (m *Requests) Describe(ch chan<- *prometheus.Desc) {
+	m.total.Describe(ch)
+	m.duration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Requests) Collect(ch chan<- prometheus.Metric) {
+	m.total.Collect(ch)
+	m.duration.Collect(ch)
+}
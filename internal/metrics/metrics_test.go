@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequests(t *testing.T) {
+	m := NewRequests([]float64{0.1, 1})
+	m.Observe("GET /api/chirps", 200, 50*time.Millisecond)
+	m.Observe("GET /api/chirps", 200, 500*time.Millisecond)
+	m.Observe("GET /api/chirps", 404, 2*time.Second)
+	m.Observe(`GET /api/"odd"`, 200, time.Millisecond)
+
+	want := `
+# HELP chirpy_http_requests_total Requests handled, by route and status.
+# TYPE chirpy_http_requests_total counter
+chirpy_http_requests_total{route="GET /api/\"odd\"",status="200"} 1
+chirpy_http_requests_total{route="GET /api/chirps",status="200"} 2
+chirpy_http_requests_total{route="GET /api/chirps",status="404"} 1
+# HELP chirpy_http_request_duration_seconds Request latency, by route and status.
+# TYPE chirpy_http_request_duration_seconds histogram
+chirpy_http_request_duration_seconds_bucket{route="GET /api/\"odd\"",status="200",le="0.1"} 1
+chirpy_http_request_duration_seconds_bucket{route="GET /api/\"odd\"",status="200",le="1"} 1
+chirpy_http_request_duration_seconds_bucket{route="GET /api/\"odd\"",status="200",le="+Inf"} 1
+chirpy_http_request_duration_seconds_sum{route="GET /api/\"odd\"",status="200"} 0.001
+chirpy_http_request_duration_seconds_count{route="GET /api/\"odd\"",status="200"} 1
+chirpy_http_request_duration_seconds_bucket{route="GET /api/chirps",status="200",le="0.1"} 1
+chirpy_http_request_duration_seconds_bucket{route="GET /api/chirps",status="200",le="1"} 2
+chirpy_http_request_duration_seconds_bucket{route="GET /api/chirps",status="200",le="+Inf"} 2
+chirpy_http_request_duration_seconds_sum{route="GET /api/chirps",status="200"} 0.55
+chirpy_http_request_duration_seconds_count{route="GET /api/chirps",status="200"} 2
+chirpy_http_request_duration_seconds_bucket{route="GET /api/chirps",status="404",le="0.1"} 0
+chirpy_http_request_duration_seconds_bucket{route="GET /api/chirps",status="404",le="1"} 0
+chirpy_http_request_duration_seconds_bucket{route="GET /api/chirps",status="404",le="+Inf"} 1
+chirpy_http_request_duration_seconds_sum{route="GET /api/chirps",status="404"} 2
+chirpy_http_request_duration_seconds_count{route="GET /api/chirps",status="404"} 1
+`
+	if err := testutil.CollectAndCompare(m, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Reset()
+	if n := testutil.CollectAndCount(m); n != 0 {
+		t.Fatalf("expected no series after Reset, got %d", n)
+	}
+}
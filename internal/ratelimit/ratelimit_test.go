@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := New(3, time.Minute)
+	l.SetClock(func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("1.2.3.4"); !ok {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	ok, retry := l.Allow("1.2.3.4")
+	if ok {
+		t.Fatalf("request 4 should be limited")
+	}
+	if retry <= 0 || retry > 20*time.Second {
+		t.Fatalf("unexpected retry-after %v", retry)
+	}
+	if ok, _ := l.Allow("5.6.7.8"); !ok {
+		t.Fatalf("other keys should have their own bucket")
+	}
+
+	now = now.Add(retry)
+	if ok, _ := l.Allow("1.2.3.4"); !ok {
+		t.Fatalf("expected a token after waiting %v", retry)
+	}
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("1.2.3.4"); !ok {
+			t.Fatalf("request %d after a full window should be allowed", i+1)
+		}
+	}
+}
+
+func TestLimiterCleanup(t *testing.T) {
+	now := time.Now()
+	l := New(2, time.Minute)
+	l.SetClock(func() time.Time { return now })
+
+	l.Allow("idle")
+	l.Allow("busy")
+	now = now.Add(20 * time.Second)
+	l.Allow("busy")
+	now = now.Add(20 * time.Second)
+	l.Cleanup()
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Fatalf("expected refilled bucket to be dropped")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Fatalf("expected partially drained bucket to be kept")
+	}
+}
+
+func TestLimiterWindowAndReset(t *testing.T) {
+	now := time.Now()
+	l := New(5, 10*time.Minute)
+	l.SetClock(func() time.Time { return now })
+
+	for i := 0; i < 5; i++ {
+		l.Allow("k")
+	}
+	ok, retry := l.Allow("k")
+	if ok || retry != 2*time.Minute {
+		t.Fatalf("expected to be limited for 2m, got ok=%v retry=%v", ok, retry)
+	}
+
+	l.Reset("k")
+	if ok, _ := l.Allow("k"); !ok {
+		t.Fatalf("expected Reset to refill the bucket")
+	}
+}
@@ -1,37 +1,119 @@
 package main
 
 import (
-	_ "context"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
-	"sort"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/NebojsaJovanovic95/chirpy/internal/auth"
 	"github.com/NebojsaJovanovic95/chirpy/internal/database"
+	"github.com/NebojsaJovanovic95/chirpy/internal/extract"
+	"github.com/NebojsaJovanovic95/chirpy/internal/filter"
+	"github.com/NebojsaJovanovic95/chirpy/internal/metrics"
+	"github.com/NebojsaJovanovic95/chirpy/internal/ratelimit"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type apiConfig struct {
 	fileserverHits	atomic.Int32
-	db							*database.Queries
+	counters				endpointCounters
+	db							database.Querier
+	sqlDB						pinger
+	createChirpsTx	func(context.Context, []database.CreateChirpParams) ([]database.Chirp, error)
 	platform				string
 	jwtSecret				string
+	accessTokenTTL	time.Duration
+	chirpRestoreWindow	time.Duration
 	polkaKey				string
+	polkaWebhookSecret	string
+	adminToken			string
+	metricsToken		string
+	profaneWords		map[string]bool
+	minPasswordLength	int
+	maxChirpLength	int
+	chirpRateLimit	int
+	chirpRateWindow	time.Duration
+	now							func() time.Time
+	requireMixedPassword	bool
+	requireVerification	bool
+	logger					*slog.Logger
+	corsOrigins			map[string]bool
+	authLimiter			*ratelimit.Limiter
+	loginLimiter		*ratelimit.Limiter
+	trustProxy			bool
+	requestMetrics	*metrics.Requests
+	promOnce				sync.Once
+	promHandler			http.Handler
 }
 
+// pinger is the part of *sql.DB the health checks need.
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+const (
+	defaultMaxChirpLength     = 140
+	defaultMinPasswordLength  = 8
+	defaultPageLimit          = 20
+	maxPageLimit              = 100
+	passwordResetTTL          = 15 * time.Minute
+	defaultAccessTokenTTL     = time.Hour
+	refreshTokenTTL           = 60 * 24 * time.Hour
+	verificationTokenTTL      = 24 * time.Hour
+	shutdownTimeout           = 10 * time.Second
+	defaultAuthRateLimit      = 10
+	defaultLoginRateLimit     = 5
+	readinessTimeout          = 2 * time.Second
+	defaultChirpRestoreWindow = 24 * time.Hour
+	chirpPurgeInterval        = time.Hour
+	maxBodyBytes              = 1 << 20
+	maxLoginBodyBytes         = 4 << 10
+	defaultDBQueryTimeout     = 5 * time.Second
+	maxBulkChirps             = 100
+	minUsernameLength         = 3
+	maxUsernameLength         = 20
+	usernameIndex             = "users_username_lower_idx"
+	maxBioLength              = 500
+	defaultChirpRateLimit     = 10
+	defaultChirpRateWindow    = time.Minute
+)
+
+var defaultProfaneWords = []string{"kerfuffle", "sharbert", "fornax"}
+
 type loginRequest struct {
 	Email							string	`json:"email"`
+	Username					string	`json:"username"`
 	Password					string	`json:"password"`
 	ExpiresInSeconds	*int		`json:"expires_in_seconds"`
+	NoRefresh					bool		`json:"no_refresh"`
 }
 
 type Chirp struct {
@@ -40,6 +122,32 @@ type Chirp struct {
 	UpdatedAt	time.Time	`json:"updated_at"`
 	UserID		uuid.UUID	`json:"user_id"`
 	Body			string		`json:"body"`
+	ParentID	*uuid.UUID	`json:"parent_id,omitempty"`
+	Likes			int64			`json:"likes"`
+	AuthorEmail	string		`json:"author_email,omitempty"`
+}
+
+// Session describes an active refresh token without exposing the token itself.
+type Session struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// endpointCounters counts successful calls to the busiest API endpoints.
+type endpointCounters struct {
+	usersCreated  atomic.Int64
+	chirpsCreated atomic.Int64
+	logins        atomic.Int64
+	refreshes     atomic.Int64
+}
+
+// each calls f with every counter and its metric name, in a stable order.
+func (c *endpointCounters) each(f func(name string, n *atomic.Int64)) {
+	f("users_created", &c.usersCreated)
+	f("chirps_created", &c.chirpsCreated)
+	f("logins", &c.logins)
+	f("refreshes", &c.refreshes)
 }
 
 // --- Utilities ---
@@ -51,8 +159,311 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+// userIDKey holds the authenticated caller's ID, set by middlewareAuth.
+const userIDKey contextKey = "user_id"
+
+// maxRequestIDLength bounds client-supplied request IDs, which are echoed
+// into response headers and logs.
+const maxRequestIDLength = 128
+
+// middlewareRequestID tags each request with an ID, reusing an inbound
+// X-Request-ID when the client supplies a sane one.
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID reports whether id is non-empty, short and made only of
+// printable ASCII without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFromContext returns the request ID set by middlewareRequestID.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// middlewareCORS allows browsers on an allowlisted origin to call the API
+// and answers their preflight requests.
+func (cfg *apiConfig) middlewareCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The answer depends on Origin whether or not it is allowed, so
+		// caches must not hand one origin's response to another.
+		if len(cfg.corsOrigins) > 0 {
+			w.Header().Add("Vary", "Origin")
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" || !cfg.corsOrigins[origin] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the caller's address, taken from X-Forwarded-For only
+// when the server sits behind a trusted proxy. Only the rightmost entry,
+// appended by that proxy, is used: the client can put anything before it.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			last := fwd[len(fwd)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			if ip := strings.TrimSpace(last); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitKey buckets requests per path and client.
+func (cfg *apiConfig) rateLimitKey(r *http.Request) string {
+	return r.URL.Path + " " + clientIP(r, cfg.trustProxy)
+}
+
+// middlewareRateLimit rejects clients that exceed limiter with 429. A nil
+// limiter disables limiting.
+func (cfg *apiConfig) middlewareRateLimit(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, retryAfter := limiter.Allow(cfg.rateLimitKey(r)); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			respondWithError(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// middlewareInstrument records each request's latency by route pattern and
+// status. It must wrap the mux directly so the matched pattern is visible.
+func (cfg *apiConfig) middlewareInstrument(next http.Handler) http.Handler {
+	if cfg.requestMetrics == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		cfg.requestMetrics.Observe(route, rec.status, time.Since(start))
+	})
+}
+
+// middlewareAuth rejects requests without a valid access token and makes
+// the caller's ID available to next through userIDFromContext.
+func (cfg *apiConfig) middlewareAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		userID, err := auth.ValidateJWT(tokenString, cfg.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// userIDFromContext returns the caller's ID set by middlewareAuth.
+func userIDFromContext(ctx context.Context) uuid.UUID {
+	id, _ := ctx.Value(userIDKey).(uuid.UUID)
+	return id
+}
+
+// middlewareAdmin requires the ADMIN_TOKEN as a bearer token. With no token
+// configured every request is refused rather than let through.
+func (cfg *apiConfig) middlewareAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearer(r, cfg.adminToken) {
+			respondWithError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareMetrics requires the METRICS_TOKEN as a bearer token when one
+// is configured, so a Prometheus scraper can read /metrics without holding
+// the admin token. With no token configured /metrics is open.
+func (cfg *apiConfig) middlewareMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.metricsToken != "" && !hasBearer(r, cfg.metricsToken) {
+			respondWithError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasBearer reports whether r carries want as its bearer token. An empty
+// want matches nothing.
+func hasBearer(r *http.Request, want string) bool {
+	token, err := auth.GetBearerToken(r.Header)
+	return err == nil && want != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// middlewareLog emits one structured log line per request.
+func (cfg *apiConfig) middlewareLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		cfg.logger.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"size", rec.size,
+			"duration", time.Since(start),
+			"request_id", requestIDFromContext(r.Context()),
+		)
+	})
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string) {
-	respondWithJSON(w, code, map[string]string{"error": msg})
+	body := map[string]string{"error": msg}
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		body["request_id"] = id
+	}
+	respondWithJSON(w, code, body)
+}
+
+// respondWithValidationError answers 400 with a message for each field
+// that failed validation, so clients can point at every problem at once.
+func respondWithValidationError(w http.ResponseWriter, fields map[string]string) {
+	body := map[string]interface{}{"error": "validation failed", "fields": fields}
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		body["request_id"] = id
+	}
+	respondWithJSON(w, http.StatusBadRequest, body)
+}
+
+// respondWithDBError answers a failed query with 503 when it ran out of
+// time, so clients know to retry, and with 500 and msg otherwise.
+func respondWithDBError(w http.ResponseWriter, err error, msg string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		respondWithError(w, http.StatusServiceUnavailable, "database timed out")
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, msg)
+}
+
+// decodeJSON reads a JSON body of at most limit bytes into dst, rejecting
+// fields dst doesn't have so client typos don't pass silently. When it
+// can't decode, it answers with 413 or 400 itself and returns false. The
+// 400 says whether the body was empty, not JSON, or had a bad field.
+func decodeJSON(w http.ResponseWriter, r *http.Request, limit int64, dst interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		respondWithError(w, http.StatusRequestEntityTooLarge, "request body too large")
+	case errors.Is(err, io.EOF):
+		respondWithError(w, http.StatusBadRequest, "request body is empty")
+	case errors.As(err, &syntaxErr):
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("malformed JSON at byte %d", syntaxErr.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		respondWithError(w, http.StatusBadRequest, "malformed JSON: unexpected end of body")
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("field %q has the wrong type", typeErr.Field))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		respondWithError(w, http.StatusBadRequest, "unknown field "+strings.TrimPrefix(err.Error(), "json: unknown field "))
+	default:
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+	}
+	return false
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -63,438 +474,2195 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	}
 }
 
-// --- Handlers ---
+// chirpWithLikes converts a database chirp into its API shape, including the
+// current like count. List queries return the count with each row, so
+// they use chirpResponse instead of paying a query per chirp.
+func (cfg *apiConfig) chirpWithLikes(ctx context.Context, c database.Chirp) (Chirp, error) {
+	likes, err := cfg.db.CountLikes(ctx, c.ID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirpResponse(c, likes), nil
+}
 
-func (cfg *apiConfig) handlePolkaWebhook(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+// chirpResponse converts a database chirp and its like count into its API
+// shape.
+func chirpResponse(c database.Chirp, likes int64) Chirp {
+	return Chirp{
+		ID:        c.ID,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		Body:      c.Body,
+		UserID:    c.UserID,
+		ParentID:  uuidPtr(c.ParentID),
+		Likes:     likes,
 	}
+}
 
-	apiKey, err := auth.GetAPIKey(r.Header)
-	if err != nil || apiKey != cfg.polkaKey {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+// issueRefreshToken creates a new refresh token for userID, storing only its
+// hash, and returns the raw token for the client.
+func (cfg *apiConfig) issueRefreshToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
 	}
-	
-	defer r.Body.Close()
+	err = cfg.db.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		Token:     auth.HashToken(refreshToken),
+		UserID:    uuid.NullUUID{UUID: userID, Valid: true},
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
 
-	var payload struct {
-		Event string `json:"event"`
-		Data struct {
-			UserID uuid.UUID `json:"user_id"`
-		} `json:"data"`
+// sessionID shortens a stored refresh token hash into an identifier that
+// can be shown to clients without revealing the token.
+func sessionID(tokenHash string) string {
+	if len(tokenHash) > 16 {
+		return tokenHash[:16]
 	}
+	return tokenHash
+}
 
-	if err :=  json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+// uuidPtr returns nil for a NULL UUID so it serializes as an omitted field.
+func uuidPtr(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
 	}
-	
-	if payload.Event != "user.upgraded" {
-		w.WriteHeader(http.StatusNoContent)
-		return
+	return &id.UUID
+}
+
+// stringPtr turns a nullable column into a pointer that encodes as JSON
+// null when the column is NULL.
+func stringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
 	}
+	return &s.String
+}
 
-	if err := cfg.db.UpgradeUserToChirpyRed(r.Context(), payload.Data.UserID); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			return
+// isUniqueViolation reports whether err is a Postgres unique constraint
+// violation (SQLSTATE 23505).
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isForeignKeyViolation reports whether err is a Postgres foreign key
+// violation (SQLSTATE 23503). An access token outlives its user, so a
+// write referencing the caller fails this way once the account is gone.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// userConflictMessage explains a unique violation on users: the username
+// index has its own message and every other index guards the email.
+func userConflictMessage(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Constraint == usernameIndex {
+		return "username already taken"
+	}
+	return "email already registered"
+}
+
+// normalizeUsername lowercases username and checks it is 3 to 20 letters,
+// digits or underscores. Usernames can't contain '@', so they never look
+// like an email at login.
+func normalizeUsername(username string) (string, error) {
+	username = strings.ToLower(username)
+	valid := len(username) >= minUsernameLength && len(username) <= maxUsernameLength
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			valid = false
 		}
-		w.WriteHeader(http.StatusInternalServerError)
-		return
 	}
-	
-	w.WriteHeader(http.StatusNoContent)
+	if !valid {
+		return "", fmt.Errorf("username must be %d to %d letters, digits or underscores", minUsernameLength, maxUsernameLength)
+	}
+	return username, nil
 }
 
-func (cfg *apiConfig) handleUsers(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPut {
-		cfg.handleUpdateUser(w, r)
-		return
+// normalizeEmail lowercases email, so addresses that differ only in case
+// name the same account however they were typed.
+func normalizeEmail(email string) string {
+	return strings.ToLower(email)
+}
+
+// validateEmail checks email is present and a bare address, without a
+// display name or angle brackets.
+func validateEmail(email string) error {
+	if email == "" {
+		return errors.New("email is required")
 	}
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("email is not a valid address")
 	}
+	return nil
+}
 
-	defer r.Body.Close()
-	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+// validateAvatarURL checks avatarURL is an absolute http or https URL.
+// The empty string is allowed and clears the avatar.
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "invalid request body")
-		return
+	u, err := url.Parse(avatarURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("avatar_url must be an http or https URL")
 	}
+	return nil
+}
 
-	hashedPassword, err := auth.HashPassword(req.Password)
+// validatePassword applies the signup password policy shared by user
+// creation and updates.
+func (cfg *apiConfig) validatePassword(password string) error {
+	if password == "" {
+		return errors.New("password is required")
+	}
+	if len(password) < cfg.minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", cfg.minPasswordLength)
+	}
+	if cfg.requireMixedPassword && passwordClasses(password) < 3 {
+		return errors.New("password must mix at least three of: lowercase, uppercase, digits, symbols")
+	}
+	return nil
+}
+
+// passwordClasses counts how many of lowercase, uppercase, digits and other
+// characters appear in password.
+func passwordClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// validateChirpBody rejects chirps that are blank or too long. Length is
+// counted in runes so multi-byte characters count once each. Callers trim
+// surrounding whitespace first so padding is neither stored nor counted.
+func (cfg *apiConfig) validateChirpBody(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return errors.New("chirp cannot be empty")
+	}
+	if utf8.RuneCountInString(body) > cfg.maxChirpLength {
+		return errors.New("chirp is too long")
+	}
+	return nil
+}
+
+// envInt reads an integer from the environment, returning fallback when the
+// variable is unset or not a valid integer.
+func envInt(name string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to hash password")
-		return
+		return fallback
+	}
+	return v
+}
+
+// newLimiter returns a limiter allowing limit requests per window. A limit
+// of 0 or less turns limiting off: it returns nil, which
+// middlewareRateLimit lets through, instead of a limiter that blocks all.
+func newLimiter(limit int, window time.Duration) *ratelimit.Limiter {
+	if limit <= 0 {
+		return nil
 	}
+	return ratelimit.New(limit, window)
+}
 
-	user, err := cfg.db.CreateUserWithPassword(r.Context(), database.CreateUserWithPasswordParams{
-		Email:          req.Email,
-		HashedPassword: hashedPassword,
-	})
+// envDuration reads a time.Duration such as "90s" from the environment,
+// returning fallback when the variable is unset, invalid or not positive.
+func envDuration(name string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// envLogLevel reads a slog level such as "debug" or "warn" from the
+// environment, defaulting to info.
+func envLogLevel(name string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv(name))); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// parseProfaneWords turns a comma-separated word list into a lookup set,
+// falling back to defaultProfaneWords when the list is empty.
+func parseProfaneWords(list string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.Split(list, ",") {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" {
+			words[word] = true
+		}
+	}
+	if len(words) == 0 {
+		for _, word := range defaultProfaneWords {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// parseOrigins turns a comma-separated origin list into a lookup set.
+func parseOrigins(list string) map[string]bool {
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// parsePagination reads the limit and offset query params. A missing limit
+// falls back to defaultPageLimit and anything above maxPageLimit is capped.
+func parsePagination(query url.Values) (limit, offset int, err error) {
+	limit = defaultPageLimit
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return 0, 0, errors.New("limit must be a positive integer")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// parseTimeWindow reads the optional RFC3339 start and end query params,
+// which bound created_at as [start, end).
+func parseTimeWindow(query url.Values) (start, end sql.NullTime, err error) {
+	parse := func(name string) (sql.NullTime, error) {
+		v := query.Get(name)
+		if v == "" {
+			return sql.NullTime{}, nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return sql.NullTime{}, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+		}
+		return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+	}
+	if start, err = parse("start"); err != nil {
+		return start, end, err
+	}
+	if end, err = parse("end"); err != nil {
+		return start, end, err
+	}
+	if start.Valid && end.Valid && start.Time.After(end.Time) {
+		return start, end, errors.New("start must not be after end")
+	}
+	return start, end, nil
+}
+
+// newServer returns an http.Server with timeouts that keep slow clients
+// from holding connections open indefinitely.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+// shutdown stops the server from accepting new connections, waits up to
+// timeout for in-flight requests to finish, and then closes the database.
+func shutdown(ctx context.Context, server *http.Server, db io.Closer, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	slog.Info("shutting down server")
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Error("server did not drain cleanly", "error", err)
+	} else {
+		slog.Info("server drained")
+	}
+
+	if cerr := db.Close(); cerr != nil {
+		slog.Error("error closing database", "error", cerr)
+		if err == nil {
+			err = cerr
+		}
+	} else {
+		slog.Info("database closed")
+	}
+	return err
+}
+
+// --- Handlers ---
+
+// handlePolkaWebhook authenticates Polka with an HMAC signature of the body
+// when POLKA_WEBHOOK_SECRET is set, and with the shared API key otherwise.
+func (cfg *apiConfig) handlePolkaWebhook(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to create user")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if cfg.polkaWebhookSecret != "" {
+		if !auth.ValidSignature(body, r.Header.Get("X-Signature"), cfg.polkaWebhookSecret) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	} else {
+		apiKey, err := auth.GetAPIKey(r.Header)
+		if err != nil || apiKey != cfg.polkaKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var payload polkaEvent
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Polka retries deliveries, so skip events we've already handled. An
+	// event that fails is forgotten again so its retry gets processed.
+	eventID := r.Header.Get("Idempotency-Key")
+	if eventID == "" {
+		eventID = payload.EventID
+	}
+	if eventID == "" {
+		eventID = payload.ID
+	}
+	if eventID != "" {
+		recorded, err := cfg.db.RecordProcessedWebhook(r.Context(), eventID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if recorded == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	status := cfg.applyPolkaEvent(r.Context(), payload)
+	if status != http.StatusNoContent && eventID != "" {
+		if err := cfg.db.DeleteProcessedWebhook(r.Context(), eventID); err != nil {
+			cfg.logger.Error("polka webhook: failed to forget event", "event_id", eventID, "error", err)
+		}
+	}
+	w.WriteHeader(status)
+}
+
+// polkaEvent is the body of a Polka webhook delivery.
+type polkaEvent struct {
+	ID      string `json:"id"`
+	EventID string `json:"event_id"`
+	Event   string `json:"event"`
+	Data  struct {
+		UserID uuid.UUID `json:"user_id"`
+	} `json:"data"`
+}
+
+// applyPolkaEvent performs event and returns the status to answer with.
+// Events we don't handle are acknowledged so Polka stops sending them.
+func (cfg *apiConfig) applyPolkaEvent(ctx context.Context, event polkaEvent) int {
+	var update func(context.Context, uuid.UUID) (int64, error)
+	switch event.Event {
+	case "user.upgraded":
+		update = cfg.db.UpgradeUserToChirpyRed
+	case "user.downgraded":
+		update = cfg.db.DowngradeUserFromChirpyRed
+	default:
+		cfg.logger.Debug("ignoring polka event", "event", event.Event)
+		return http.StatusNoContent
+	}
+
+	updated, err := update(ctx, event.Data.UserID)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	if updated == 0 {
+		return http.StatusNotFound
+	}
+	return http.StatusNoContent
+}
+
+func (cfg *apiConfig) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req struct {
+		Email    string `json:"email"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if !decodeJSON(w, r, maxBodyBytes, &req) {
+		return
+	}
+
+	req.Email = normalizeEmail(req.Email)
+	fields := map[string]string{}
+	if err := validateEmail(req.Email); err != nil {
+		fields["email"] = err.Error()
+	}
+	var username sql.NullString
+	if req.Username != "" {
+		normalized, err := normalizeUsername(req.Username)
+		if err != nil {
+			fields["username"] = err.Error()
+		}
+		username = sql.NullString{String: normalized, Valid: true}
+	}
+	if err := cfg.validatePassword(req.Password); err != nil {
+		fields["password"] = err.Error()
+	}
+	if len(fields) > 0 {
+		respondWithValidationError(w, fields)
+		return
+	}
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+
+	user, err := cfg.db.CreateUserWithPassword(r.Context(), database.CreateUserWithPasswordParams{
+		Email:          req.Email,
+		HashedPassword: hashedPassword,
+		Username:       username,
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, userConflictMessage(err))
+			return
+		}
+		respondWithDBError(w, err, "failed to create user")
+		return
+	}
+
+	verificationToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create verification token")
+		return
+	}
+	err = cfg.db.CreateVerificationToken(r.Context(), database.CreateVerificationTokenParams{
+		Token:     auth.HashToken(verificationToken),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(verificationTokenTTL),
+	})
+	if err != nil {
+		respondWithDBError(w, err, "failed to store verification token")
+		return
+	}
+
+	// There is no mail delivery yet, so surface the token in dev logs only.
+	if cfg.platform == "dev" {
+		cfg.logger.Info("verification token issued", "email", user.Email, "token", verificationToken)
+	}
+
+	cfg.counters.usersCreated.Add(1)
+	w.WriteHeader(http.StatusCreated)
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":         user.ID,
+		"email":      user.Email,
+		"username":   stringPtr(user.Username),
+		"avatar_url": user.AvatarUrl,
+		"bio":        user.Bio,
+		"created_at": user.CreatedAt,
+		"updated_at": user.UpdatedAt,
+		"is_chirpy_red": user.IsChirpyRed,
+	})
+}
+
+func (cfg *apiConfig) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+	defer r.Body.Close()
+	// Pointers tell an omitted field, which is left unchanged, apart from
+	// an empty one, which is rejected.
+	var req struct{
+		Email			*string `json:"email"`
+		Password	*string `json:"password"`
+		AvatarURL	*string `json:"avatar_url"`
+		Bio				*string `json:"bio"`
+	}
+	if !decodeJSON(w, r, maxBodyBytes, &req) {
+		return
+	}
+	if req.Email == nil && req.Password == nil && req.AvatarURL == nil && req.Bio == nil {
+		respondWithError(w, http.StatusBadRequest, "email, password, avatar_url or bio is required")
+		return
+	}
+	params := database.UpdateUserParams{ID: userID}
+	if req.Email != nil {
+		if *req.Email == "" {
+			respondWithError(w, http.StatusBadRequest, "email cannot be empty")
+			return
+		}
+		params.Email = sql.NullString{String: normalizeEmail(*req.Email), Valid: true}
+	}
+	if req.Password != nil {
+		if err := cfg.validatePassword(*req.Password); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hashedPassword, err := auth.HashPassword(*req.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to hash password")
+			return
+		}
+		params.HashedPassword = sql.NullString{String: hashedPassword, Valid: true}
+	}
+	if req.AvatarURL != nil {
+		if err := validateAvatarURL(*req.AvatarURL); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params.AvatarUrl = sql.NullString{String: *req.AvatarURL, Valid: true}
+	}
+	if req.Bio != nil {
+		if utf8.RuneCountInString(*req.Bio) > maxBioLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("bio must be at most %d characters", maxBioLength))
+			return
+		}
+		params.Bio = sql.NullString{String: *req.Bio, Valid: true}
+	}
+	user, err := cfg.db.UpdateUser(r.Context(), params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusUnauthorized, "user no longer exists")
+			return
+		}
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, userConflictMessage(err))
+			return
+		}
+		respondWithDBError(w, err, "failed to update user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"id":					user.ID,
+		"email":			user.Email,
+		"username":		stringPtr(user.Username),
+		"avatar_url":	user.AvatarUrl,
+		"bio":				user.Bio,
+		"created_at":	user.CreatedAt,
+		"updated_at":	user.UpdatedAt,
+		"is_chirpy_red": user.IsChirpyRed,
+	})
+}
+
+func (cfg *apiConfig) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	// Chirps, likes and refresh tokens are removed by ON DELETE CASCADE.
+	// Other users' replies to the removed chirps stay, with parent_id
+	// cleared by ON DELETE SET NULL.
+	deleted, err := cfg.db.DeleteUser(r.Context(), userID)
+	if err != nil {
+		respondWithDBError(w, err, "failed to delete user")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to fetch user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"id":            user.ID,
+		"email":         user.Email,
+		"username":      stringPtr(user.Username),
+		"avatar_url":    user.AvatarUrl,
+		"bio":           user.Bio,
+		"created_at":    user.CreatedAt,
+		"updated_at":    user.UpdatedAt,
+		"is_chirpy_red": user.IsChirpyRed,
+	})
+}
+
+// handleMe returns the profile of the user the access token belongs to.
+func (cfg *apiConfig) handleMe(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to fetch user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"id":            user.ID,
+		"email":         user.Email,
+		"username":      stringPtr(user.Username),
+		"avatar_url":    user.AvatarUrl,
+		"bio":           user.Bio,
+		"created_at":    user.CreatedAt,
+		"updated_at":    user.UpdatedAt,
+		"is_chirpy_red": user.IsChirpyRed,
+	})
+}
+
+// handleFollow serves both POST (follow) and DELETE (unfollow).
+func (cfg *apiConfig) handleFollow(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	userID := userIDFromContext(r.Context())
+
+	if followeeID == userID {
+		respondWithError(w, http.StatusBadRequest, "cannot follow yourself")
+		return
+	}
+	if _, err := cfg.db.GetUserByID(r.Context(), followeeID); err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to fetch user")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err = cfg.db.CreateFollow(r.Context(), database.CreateFollowParams{
+			FollowerID: userID,
+			FolloweeID: followeeID,
+		})
+	} else {
+		err = cfg.db.DeleteFollow(r.Context(), database.DeleteFollowParams{
+			FollowerID: userID,
+			FolloweeID: followeeID,
+		})
+	}
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			respondWithError(w, http.StatusUnauthorized, "user no longer exists")
+			return
+		}
+		respondWithDBError(w, err, "failed to update follow")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handleFeed(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	limit, offset, err := parsePagination(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chirps, err := cfg.db.GetFeed(r.Context(), database.GetFeedParams{
+		FollowerID: userID,
+		Limit:      int32(limit),
+		Offset:     int32(offset),
+	})
+	if err != nil {
+		respondWithDBError(w, err, "failed to fetch feed")
+		return
+	}
+
+	result := make([]Chirp, 0, len(chirps))
+	for _, row := range chirps {
+		result = append(result, chirpResponse(row.Chirp, row.Likes))
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// handleMentions lists chirps that @mention the caller, newest first.
+func (cfg *apiConfig) handleMentions(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	limit, offset, err := parsePagination(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chirps, err := cfg.db.GetChirpsMentioningUser(r.Context(), database.GetChirpsMentioningUserParams{
+		UserID: userID,
+		Limit:  int32(limit),
+		Offset: int32(offset),
+	})
+	if err != nil {
+		respondWithDBError(w, err, "failed to fetch mentions")
+		return
+	}
+
+	result := make([]Chirp, 0, len(chirps))
+	for _, row := range chirps {
+		result = append(result, chirpResponse(row.Chirp, row.Likes))
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// userForLogin finds the account a login names. The identifier comes from
+// email, or username when email is empty, and is tried as an email first
+// and then as a username.
+func (cfg *apiConfig) userForLogin(ctx context.Context, req loginRequest) (database.GetUserByEmailRow, error) {
+	identifier := normalizeEmail(req.Email)
+	if identifier == "" {
+		identifier = req.Username
+	}
+	if identifier == "" {
+		return database.GetUserByEmailRow{}, sql.ErrNoRows
+	}
+	user, err := cfg.db.GetUserByEmail(ctx, identifier)
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+	byUsername, err := cfg.db.GetUserByUsername(ctx, identifier)
+	return database.GetUserByEmailRow(byUsername), err
+}
+
+func (cfg *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req loginRequest
+	if !decodeJSON(w, r, maxLoginBodyBytes, &req) {
+		return
+	}
+	fields := map[string]string{}
+	if req.Email == "" && req.Username == "" {
+		fields["email"] = "email or username is required"
+	}
+	if req.Password == "" {
+		fields["password"] = "password is required"
+	}
+	if len(fields) > 0 {
+		respondWithValidationError(w, fields)
+		return
+	}
+
+	user, err := cfg.userForLogin(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			respondWithDBError(w, err, "failed to fetch user")
+			return
+		}
+		respondWithError(w, http.StatusUnauthorized, "incorrect email or password")
+		return
+	}
+
+	match, err := auth.CheckPasswordHash(req.Password, user.HashedPassword)
+	if err != nil || !match {
+		respondWithError(w, http.StatusUnauthorized, "incorrect email or password")
+		return
+	}
+	if cfg.loginLimiter != nil {
+		cfg.loginLimiter.Reset(cfg.rateLimitKey(r))
+	}
+
+	expires := cfg.accessTokenTTL
+	if req.ExpiresInSeconds != nil {
+		requested := time.Duration(*req.ExpiresInSeconds) * time.Second
+		if requested < expires {
+			expires = requested
+		}
+	}
+
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, expires)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "could not create token")
+		return
+	}
+
+	resp := map[string]interface{}{
+		"id":							user.ID,
+		"email":					user.Email,
+		"username":				stringPtr(user.Username),
+		"avatar_url":			user.AvatarUrl,
+		"bio":						user.Bio,
+		"created_at":			user.CreatedAt,
+		"updated_at":			user.UpdatedAt,
+		"is_chirpy_red": user.IsChirpyRed,
+		"token":					token,
+		"expires_in":			int(expires.Seconds()),
+		"expires_at":			time.Now().Add(expires).UTC(),
+	}
+	// Short-lived clients can opt out of a long-lived refresh token.
+	if !req.NoRefresh {
+		refreshToken, err := cfg.issueRefreshToken(r.Context(), user.ID)
+		if err != nil {
+			respondWithDBError(w, err, "failed to create refresh token")
+			return
+		}
+		resp["refresh_token"] = refreshToken
+	}
+
+	cfg.counters.logins.Add(1)
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handleVerify(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decodeJSON(w, r, maxBodyBytes, &req) {
+		return
+	}
+
+	userID, err := cfg.db.ConsumeVerificationToken(r.Context(), auth.HashToken(req.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusBadRequest, "invalid or expired verification token")
+			return
+		}
+		respondWithDBError(w, err, "failed to use verification token")
+		return
+	}
+
+	if err := cfg.db.MarkUserVerified(r.Context(), userID); err != nil {
+		respondWithDBError(w, err, "failed to verify user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decodeJSON(w, r, maxBodyBytes, &req) {
+		return
+	}
+
+	// Respond the same way whether or not the email exists so the endpoint
+	// can't be used to enumerate accounts.
+	user, err := cfg.db.GetUserByEmail(r.Context(), normalizeEmail(req.Email))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			cfg.logger.Error("password reset: failed to look up user", "error", err)
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	resetToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create reset token")
+		return
+	}
+	// Only the hash is stored, like refresh tokens, so a leaked table
+	// can't be used to take over accounts.
+	err = cfg.db.CreatePasswordResetToken(r.Context(), database.CreatePasswordResetTokenParams{
+		Token:     auth.HashToken(resetToken),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		respondWithDBError(w, err, "failed to store reset token")
+		return
+	}
+
+	// There is no mail delivery yet, so surface the token in dev logs only.
+	if cfg.platform == "dev" {
+		cfg.logger.Info("password reset token issued", "email", user.Email, "token", resetToken)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlePasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if !decodeJSON(w, r, maxBodyBytes, &req) {
+		return
+	}
+	if err := cfg.validatePassword(req.Password); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, err := cfg.db.ConsumePasswordResetToken(r.Context(), auth.HashToken(req.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusBadRequest, "invalid, expired or already used reset token")
+			return
+		}
+		respondWithDBError(w, err, "failed to use reset token")
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+	err = cfg.db.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             userID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		respondWithDBError(w, err, "failed to update password")
+		return
+	}
+
+	// Whoever had the old password may still hold a session; end them all.
+	owner := uuid.NullUUID{UUID: userID, Valid: true}
+	if err := cfg.db.RevokeAllRefreshTokensForUser(r.Context(), owner); err != nil {
+		respondWithDBError(w, err, "failed to revoke sessions")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "missing refresh token")
+		return
+	}
+	tokenHash := auth.HashToken(refreshToken)
+	user, err := cfg.db.GetUserFromValidRefreshToken(r.Context(), tokenHash)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			respondWithDBError(w, err, "failed to look up refresh token")
+			return
+		}
+		respondWithError(w, http.StatusUnauthorized, cfg.refreshTokenProblem(r.Context(), tokenHash))
+		return
+	}
+
+	newToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, cfg.accessTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "could not create access token")
+		return
+	}
+
+	// Rotate: the presented refresh token is spent and replaced by a new one.
+	// Only one concurrent refresh can win the conditional revoke; any other
+	// request replaying the same token is rejected.
+	revoked, err := cfg.db.RevokeActiveRefreshToken(r.Context(), tokenHash)
+	if err != nil {
+		respondWithDBError(w, err, "failed to revoke token")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusUnauthorized, "refresh token already used")
+		return
+	}
+	newRefreshToken, err := cfg.issueRefreshToken(r.Context(), user.ID)
+	if err != nil {
+		respondWithDBError(w, err, "failed to create refresh token")
+		return
+	}
+
+	cfg.counters.refreshes.Add(1)
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"token":         newToken,
+		"refresh_token": newRefreshToken,
+		"expires_in":    int(cfg.accessTokenTTL.Seconds()),
+		"expires_at":    time.Now().Add(cfg.accessTokenTTL).UTC(),
+	})
+}
+
+// refreshTokenProblem explains why tokenHash was rejected. It only picks
+// the error message; validity is decided by GetUserFromValidRefreshToken.
+func (cfg *apiConfig) refreshTokenProblem(ctx context.Context, tokenHash string) string {
+	tokenRow, err := cfg.db.GetRefreshToken(ctx, tokenHash)
+	switch {
+	case err != nil:
+		return "invalid refresh token"
+	case tokenRow.RevokedAt.Valid:
+		return "refresh token revoked"
+	case !tokenRow.ExpiresAt.After(time.Now()):
+		return "refresh token expired"
+	default:
+		return "invalid refresh token"
+	}
+}
+
+func (cfg *apiConfig) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "missing refresh token")
+		return
+	}
+
+	err = cfg.db.RevokeRefreshToken(r.Context(), database.RevokeRefreshTokenParams{
+		Token:     auth.HashToken(refreshToken),
+		RevokedAt: sql.NullTime{
+			Time:		time.Now(),
+			Valid:	true,
+		},
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		respondWithDBError(w, err, "failed to revoke token")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent) // 204
+}
+
+// handleLogoutAll revokes every active refresh token for the caller,
+// signing them out of all devices.
+func (cfg *apiConfig) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	owner := uuid.NullUUID{UUID: userID, Valid: true}
+	if err := cfg.db.RevokeAllRefreshTokensForUser(r.Context(), owner); err != nil {
+		respondWithDBError(w, err, "failed to revoke sessions")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	tokens, err := cfg.db.GetActiveRefreshTokensForUser(r.Context(), uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithDBError(w, err, "failed to fetch sessions")
+		return
+	}
+
+	sessions := make([]Session, 0, len(tokens))
+	for _, t := range tokens {
+		sessions = append(sessions, Session{
+			ID:        sessionID(t.Token),
+			CreatedAt: t.CreatedAt,
+			ExpiresAt: t.ExpiresAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// requireVerifiedAuthor checks that the caller still exists and, when
+// REQUIRE_EMAIL_VERIFICATION is on, has verified their email, answering
+// the request itself when not.
+func (cfg *apiConfig) requireVerifiedAuthor(w http.ResponseWriter, r *http.Request) bool {
+	author, err := cfg.db.GetUserByID(r.Context(), userIDFromContext(r.Context()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusUnauthorized, "user no longer exists")
+			return false
+		}
+		respondWithDBError(w, err, "failed to fetch user")
+		return false
+	}
+	if cfg.requireVerification && !author.IsVerified {
+		respondWithError(w, http.StatusForbidden, "verify your email address before posting chirps")
+		return false
+	}
+	return true
+}
+
+// requireChirpQuota checks that n more chirps keep the caller within
+// chirpRateLimit chirps in the last chirpRateWindow, answering 429 itself
+// when not. A limit of 0 turns the check off.
+func (cfg *apiConfig) requireChirpQuota(w http.ResponseWriter, r *http.Request, n int) bool {
+	if cfg.chirpRateLimit <= 0 {
+		return true
+	}
+	posted, err := cfg.db.CountUserChirpsSince(r.Context(), database.CountUserChirpsSinceParams{
+		UserID: userIDFromContext(r.Context()),
+		Since:  cfg.now().Add(-cfg.chirpRateWindow),
+	})
+	if err != nil {
+		respondWithDBError(w, err, "failed to count recent chirps")
+		return false
+	}
+	if posted+int64(n) > int64(cfg.chirpRateLimit) {
+		// The oldest chirp in the window ages out within a full window.
+		seconds := int(math.Ceil(cfg.chirpRateWindow.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		respondWithError(w, http.StatusTooManyRequests, "too many chirps, try again later")
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handleCreateChirp(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID := userIDFromContext(r.Context())
+	if !cfg.requireVerifiedAuthor(w, r) {
+		return
+	}
+	var req struct {
+		Body     string     `json:"body"`
+		ParentID *uuid.UUID `json:"parent_id"`
+	}
+	if !decodeJSON(w, r, maxBodyBytes, &req) {
+		return
+	}
+
+	req.Body = strings.TrimSpace(req.Body)
+	if err := cfg.validateChirpBody(req.Body); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !cfg.requireChirpQuota(w, r, 1) {
+		return
+	}
+	cleaned := filter.Clean(req.Body, cfg.profaneWords)
+
+	var parentID uuid.NullUUID
+	if req.ParentID != nil {
+		if _, err := cfg.db.GetChirp(r.Context(), *req.ParentID); err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, http.StatusBadRequest, "parent chirp does not exist")
+				return
+			}
+			respondWithDBError(w, err, "failed to fetch parent chirp")
+			return
+		}
+		parentID = uuid.NullUUID{UUID: *req.ParentID, Valid: true}
+	}
+
+	chirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
+		Body:     cleaned,
+		UserID:   userID,
+		ParentID: parentID,
+	})
+	if err != nil {
+		respondWithDBError(w, err, "failed to create chirp")
+		return
+	}
+	cfg.indexChirp(r.Context(), chirp)
+
+	cfg.counters.chirpsCreated.Add(1)
+	respondWithJSON(w, http.StatusCreated, Chirp{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserID:    chirp.UserID,
+		ParentID:  uuidPtr(chirp.ParentID),
+	})
+}
+
+// indexChirp records the hashtags and @mentions in a new chirp. A mention
+// matches the user whose username equals the handle, ignoring case, and
+// handles that match nobody are skipped. The chirp is
+// already stored, so failures only cost discoverability and are logged
+// rather than failing the request.
+func (cfg *apiConfig) indexChirp(ctx context.Context, chirp database.Chirp) {
+	for _, tag := range extract.Hashtags(chirp.Body) {
+		err := cfg.db.CreateChirpTag(ctx, database.CreateChirpTagParams{ChirpID: chirp.ID, Tag: tag})
+		if err != nil {
+			cfg.logger.Error("failed to tag chirp", "chirp_id", chirp.ID, "tag", tag, "error", err)
+		}
+	}
+	for _, handle := range extract.Mentions(chirp.Body) {
+		user, err := cfg.db.GetUserByUsername(ctx, handle)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				cfg.logger.Error("failed to look up mentioned user", "chirp_id", chirp.ID, "handle", handle, "error", err)
+			}
+			continue
+		}
+		err = cfg.db.CreateChirpMention(ctx, database.CreateChirpMentionParams{ChirpID: chirp.ID, UserID: user.ID})
+		if err != nil {
+			cfg.logger.Error("failed to record mention", "chirp_id", chirp.ID, "handle", handle, "error", err)
+		}
+	}
+}
+
+// handleBulkCreateChirps creates up to maxBulkChirps chirps in one
+// transaction. Every body is validated first and a single bad one rejects
+// the whole batch, so an import never half-applies.
+func (cfg *apiConfig) handleBulkCreateChirps(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID := userIDFromContext(r.Context())
+	if !cfg.requireVerifiedAuthor(w, r) {
+		return
+	}
+	var req struct {
+		Chirps []string `json:"chirps"`
+	}
+	if !decodeJSON(w, r, maxBodyBytes, &req) {
+		return
+	}
+	if len(req.Chirps) == 0 {
+		respondWithError(w, http.StatusBadRequest, "chirps is required")
+		return
+	}
+	if len(req.Chirps) > maxBulkChirps {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("at most %d chirps per batch", maxBulkChirps))
+		return
+	}
+	if !cfg.requireChirpQuota(w, r, len(req.Chirps)) {
+		return
+	}
+
+	params := make([]database.CreateChirpParams, 0, len(req.Chirps))
+	for i, body := range req.Chirps {
+		body = strings.TrimSpace(body)
+		if err := cfg.validateChirpBody(body); err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("chirps[%d]: %v", i, err))
+			return
+		}
+		params = append(params, database.CreateChirpParams{
+			Body:   filter.Clean(body, cfg.profaneWords),
+			UserID: userID,
+		})
+	}
+
+	chirps, err := cfg.createChirpsTx(r.Context(), params)
+	if err != nil {
+		respondWithDBError(w, err, "failed to create chirps")
+		return
+	}
+
+	result := make([]Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		cfg.indexChirp(r.Context(), chirp)
+		result = append(result, Chirp{
+			ID:        chirp.ID,
+			CreatedAt: chirp.CreatedAt,
+			UpdatedAt: chirp.UpdatedAt,
+			Body:      chirp.Body,
+			UserID:    chirp.UserID,
+		})
+	}
+	cfg.counters.chirpsCreated.Add(int64(len(chirps)))
+	respondWithJSON(w, http.StatusCreated, result)
+}
+
+func (cfg *apiConfig) handleListChirps(w http.ResponseWriter, r *http.Request) {
+	authorIDStr := r.URL.Query().Get("author_id")
+	sortOrder := r.URL.Query().Get("sort")
+	if sortOrder == "" {
+		sortOrder = "asc"
+	}
+	if sortOrder != "asc" && sortOrder != "desc" {
+		respondWithError(w, http.StatusBadRequest, "sort must be asc or desc")
+		return
+	}
+
+	limit, offset, err := parsePagination(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	start, end, err := parseTimeWindow(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetChirpsPagedParams{
+		Start:     start,
+		End:       end,
+		SortDesc:  sortOrder == "desc",
+		RowLimit:  int32(limit),
+		RowOffset: int32(offset),
+	}
+	if authorIDStr != "" {
+		authorID, parseErr := uuid.Parse(authorIDStr)
+		if parseErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+
+	include := r.URL.Query().Get("include")
+	if include != "" && include != "author" {
+		respondWithError(w, http.StatusBadRequest, "include must be author")
+		return
+	}
+
+	if countOnly := r.URL.Query().Get("count"); countOnly != "" {
+		ok, parseErr := strconv.ParseBool(countOnly)
+		if parseErr != nil {
+			respondWithError(w, http.StatusBadRequest, "count must be true or false")
+			return
+		}
+		if ok {
+			query := r.URL.Query()
+			if query.Has("q") || query.Has("include") || query.Has("after") || query.Has("before") || start.Valid || end.Valid {
+				respondWithError(w, http.StatusBadRequest, "count only combines with author_id")
+				return
+			}
+			cfg.countChirps(w, r, params.AuthorID)
+			return
+		}
+	}
+
+	if after := r.URL.Query().Get("after"); after != "" {
+		query := r.URL.Query()
+		if query.Has("offset") || query.Has("q") || query.Has("include") || query.Has("before") || start.Valid || end.Valid || sortOrder == "desc" {
+			respondWithError(w, http.StatusBadRequest, "after only combines with limit and author_id")
+			return
+		}
+		cfg.listChirpsAfter(w, r, after, limit, params.AuthorID)
+		return
+	}
+
+	// An empty before starts the walk from the newest chirp.
+	if query := r.URL.Query(); query.Has("before") {
+		if query.Has("offset") || query.Has("q") || query.Has("include") || start.Valid || end.Valid || (sortOrder == "asc" && query.Has("sort")) {
+			respondWithError(w, http.StatusBadRequest, "before only combines with limit and author_id")
+			return
+		}
+		cfg.listChirpsBefore(w, r, query.Get("before"), limit, params.AuthorID)
+		return
+	}
+
+	if include == "author" {
+		if r.URL.Query().Has("q") {
+			respondWithError(w, http.StatusBadRequest, "include=author can't be combined with q")
+			return
+		}
+		cfg.listChirpsWithAuthor(w, r, database.GetChirpsWithAuthorParams(params))
+		return
+	}
+
+	var chirps []database.GetChirpsPagedRow
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		var found []database.SearchChirpsRow
+		found, err = cfg.db.SearchChirps(r.Context(), database.SearchChirpsParams{
+			Query:     escapeLike(q),
+			AuthorID:  params.AuthorID,
+			Start:     params.Start,
+			End:       params.End,
+			SortDesc:  params.SortDesc,
+			RowLimit:  params.RowLimit,
+			RowOffset: params.RowOffset,
+		})
+		for _, row := range found {
+			chirps = append(chirps, database.GetChirpsPagedRow(row))
+		}
+	} else {
+		chirps, err = cfg.db.GetChirpsPaged(r.Context(), params)
+	}
+	if err != nil {
+		respondWithDBError(w, err, "failed to fetch chirps")
+		return
+	}
+
+	result := make([]Chirp, 0, len(chirps))
+	for _, row := range chirps {
+		result = append(result, chirpResponse(row.Chirp, row.Likes))
+	}
+	respondWithETag(w, r, chirpListETag(result), result)
+}
+
+// listChirpsWithAuthor serves the chirp list for ?include=author, joining
+// each chirp's author email so feeds needn't look every author up.
+func (cfg *apiConfig) listChirpsWithAuthor(w http.ResponseWriter, r *http.Request, params database.GetChirpsWithAuthorParams) {
+	rows, err := cfg.db.GetChirpsWithAuthor(r.Context(), params)
+	if err != nil {
+		respondWithDBError(w, err, "failed to fetch chirps")
+		return
+	}
+
+	result := make([]Chirp, 0, len(rows))
+	for _, row := range rows {
+		chirp := chirpResponse(database.Chirp{
+			ID:        row.ID,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+			Body:      row.Body,
+			UserID:    row.UserID,
+			ParentID:  row.ParentID,
+			DeletedAt: row.DeletedAt,
+		}, row.Likes)
+		chirp.AuthorEmail = row.AuthorEmail
+		result = append(result, chirp)
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// countChirps serves {"count": N} for ?count=true, optionally limited to
+// one author, so badge UIs needn't fetch every chirp.
+func (cfg *apiConfig) countChirps(w http.ResponseWriter, r *http.Request, authorID uuid.NullUUID) {
+	var n int64
+	var err error
+	if authorID.Valid {
+		n, err = cfg.db.CountChirpsByAuthor(r.Context(), authorID.UUID)
+	} else {
+		n, err = cfg.db.CountChirps(r.Context())
+	}
+	if err != nil {
+		respondWithDBError(w, err, "failed to count chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]int64{"count": n})
+}
+
+// listChirpsAfter serves the page of chirps created after the cursor,
+// oldest first. Unlike offsets, the cursor doesn't drift as chirps arrive.
+// The first page starts from a chirp id; later pages pass the opaque
+// next_cursor, which keeps working if its chirp is deleted mid-walk.
+// next_cursor is null once there is nothing left to fetch.
+func (cfg *apiConfig) listChirpsAfter(w http.ResponseWriter, r *http.Request, cursor string, limit int, authorID uuid.NullUUID) {
+	params := database.GetChirpsAfterParams{
+		AuthorID: authorID,
+		RowLimit: int32(limit + 1),
+	}
+	if cursorID, err := uuid.Parse(cursor); err == nil {
+		cursorChirp, err := cfg.db.GetChirp(r.Context(), cursorID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, http.StatusBadRequest, "invalid cursor")
+				return
+			}
+			respondWithDBError(w, err, "failed to fetch chirps")
+			return
+		}
+		params.CursorCreatedAt = cursorChirp.CreatedAt
+		params.CursorID = cursorChirp.ID
+	} else {
+		createdAt, id, err := decodeChirpCursor(cursor)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		params.CursorCreatedAt = createdAt
+		params.CursorID = id
+	}
+
+	// Ask for one extra row to learn whether another page follows.
+	chirps, err := cfg.db.GetChirpsAfter(r.Context(), params)
+	if err != nil {
+		respondWithDBError(w, err, "failed to fetch chirps")
+		return
+	}
+	var nextCursor *string
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+		next := encodeChirpCursor(chirps[limit-1].Chirp)
+		nextCursor = &next
+	}
+
+	result := make([]Chirp, 0, len(chirps))
+	for _, row := range chirps {
+		result = append(result, chirpResponse(row.Chirp, row.Likes))
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"chirps":      result,
+		"next_cursor": nextCursor,
+	})
+}
+
+// listChirpsBefore serves the page of chirps older than an opaque cursor,
+// newest first. Chirps posted mid-walk sort above the cursor, so they
+// neither shift nor repeat later pages. next_cursor is null on the last
+// page.
+func (cfg *apiConfig) listChirpsBefore(w http.ResponseWriter, r *http.Request, cursor string, limit int, authorID uuid.NullUUID) {
+	params := database.GetChirpsBeforeParams{
+		AuthorID: authorID,
+		RowLimit: int32(limit + 1),
+	}
+	if cursor != "" {
+		createdAt, id, err := decodeChirpCursor(cursor)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		params.CursorCreatedAt = sql.NullTime{Time: createdAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	// As in listChirpsAfter, the extra row says whether another page follows.
+	chirps, err := cfg.db.GetChirpsBefore(r.Context(), params)
+	if err != nil {
+		respondWithDBError(w, err, "failed to fetch chirps")
+		return
+	}
+	var nextCursor *string
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+		next := encodeChirpCursor(chirps[limit-1].Chirp)
+		nextCursor = &next
+	}
+
+	result := make([]Chirp, 0, len(chirps))
+	for _, row := range chirps {
+		result = append(result, chirpResponse(row.Chirp, row.Likes))
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"chirps":      result,
+		"next_cursor": nextCursor,
+	})
+}
+
+// encodeChirpCursor packs the created_at and id that order c into an
+// opaque, URL-safe cursor for listChirpsAfter and listChirpsBefore.
+func encodeChirpCursor(c database.Chirp) string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "_" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeChirpCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "_")
+	if !ok {
+		return time.Time{}, uuid.Nil, errors.New("cursor is missing its id")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	return t, parsedID, nil
+}
+
+func (cfg *apiConfig) handleGetChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to fetch chirp")
+		return
+	}
+
+	result, err := cfg.chirpWithLikes(r.Context(), chirp)
+	if err != nil {
+		respondWithDBError(w, err, "failed to count likes")
+		return
+	}
+
+	respondWithETag(w, r, chirpETag(result), result)
+}
+
+// respondWithETag tags a 200 response with etag, answering 304 instead
+// when If-None-Match shows the client already has that version.
+func respondWithETag(w http.ResponseWriter, r *http.Request, etag string, payload interface{}) {
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, payload)
+}
+
+// chirpETag identifies one version of a chirp response. Likes are part of
+// the response but don't touch updated_at, so they're folded in too.
+func chirpETag(c Chirp) string {
+	return fmt.Sprintf(`"%s-%x-%d"`, c.ID, c.UpdatedAt.UnixNano(), c.Likes)
+}
+
+// chirpListETag identifies one version of a chirp list by hashing the
+// ETags of its chirps in order. The newest updated_at alone would miss
+// deletions, likes and edits to older chirps.
+func chirpListETag(chirps []Chirp) string {
+	h := sha256.New()
+	for _, c := range chirps {
+		io.WriteString(h, chirpETag(c))
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+}
+
+// etagMatches reports whether an If-None-Match header lists etag or is
+// "*". Weak validators match too, as RFC 9110 asks for GET.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *apiConfig) handleDeleteChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to fetch chirp")
+		return
+	}
+	
+	if chirp.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	// The chirp stays restorable for chirpRestoreWindow before it's purged.
+	if err := cfg.db.SoftDeleteChirp(r.Context(), chirpID); err != nil {
+		respondWithDBError(w, err, "failed to delete chirp")
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
-		"id":         user.ID,
-		"email":      user.Email,
-		"created_at": user.CreatedAt,
-		"updated_at": user.UpdatedAt,
-		"is_chirpy_red": user.IsChirpyRed,
-	})
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (cfg *apiConfig) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// handleDeleteMyChirps deletes every chirp the caller has written. It only
+// ever acts on the caller's own chirps, so it takes no author parameter.
+// Each chirp stays restorable for chirpRestoreWindow, as with handleDeleteChirp.
+func (cfg *apiConfig) handleDeleteMyChirps(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+	deleted, err := cfg.db.SoftDeleteChirpsByAuthor(r.Context(), userID)
+	if err != nil {
+		respondWithDBError(w, err, "failed to delete chirps")
 		return
 	}
-	tokenString, err := auth.GetBearerToken(r.Header)
+	respondWithJSON(w, http.StatusOK, map[string]int64{"deleted": deleted})
+}
+
+// handleRestoreChirp brings back one of the caller's deleted chirps, as long
+// as it was deleted within chirpRestoreWindow.
+func (cfg *apiConfig) handleRestoreChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "missing or invalid token")
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
 		return
 	}
-	userID, err := auth.ValidateJWT(tokenString, cfg.jwtSecret)
+
+	userID := userIDFromContext(r.Context())
+	chirp, err := cfg.db.GetDeletedChirp(r.Context(), chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token")
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to fetch chirp")
 		return
 	}
-	defer r.Body.Close()
-	var req struct{
-		Email			string `json:"email"`
-		Password	string `json:"password"`
+	if chirp.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "forbidden")
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "invalid request body")
+	if time.Since(chirp.DeletedAt.Time) > cfg.chirpRestoreWindow {
+		respondWithError(w, http.StatusNotFound, "chirp not found")
 		return
 	}
-	hashedPassword, err := auth.HashPassword(req.Password)
+
+	restored, err := cfg.db.RestoreChirp(r.Context(), chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to hash password")
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to restore chirp")
 		return
 	}
-	user, err := cfg.db.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID:						userID,
-		Email:				req.Email,
-		HashedPassword:	hashedPassword,
-	})
+	result, err := cfg.chirpWithLikes(r.Context(), restored)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to update user")
+		respondWithDBError(w, err, "failed to count likes")
 		return
 	}
-	respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"id":					user.ID,
-		"email":			user.Email,
-		"created_at":	user.CreatedAt,
-		"updated_at":	user.UpdatedAt,
-		"is_chirpy_red": user.IsChirpyRed,
-	})
+	respondWithJSON(w, http.StatusOK, result)
 }
 
-func (cfg *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func (cfg *apiConfig) handleUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
 		return
 	}
 	defer r.Body.Close()
 
-	var req loginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "invalid request body")
+	userID := userIDFromContext(r.Context())
+	var req struct {
+		Body string `json:"body"`
+	}
+	if !decodeJSON(w, r, maxBodyBytes, &req) {
 		return
 	}
-
-	user, err := cfg.db.GetUserByEmail(r.Context(), req.Email)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "incorrect email or password")
+	req.Body = strings.TrimSpace(req.Body)
+	if err := cfg.validateChirpBody(req.Body); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	match, err := auth.CheckPasswordHash(req.Password, user.HashedPassword)
-	if err != nil || !match {
-		respondWithError(w, http.StatusUnauthorized, "incorrect email or password")
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to fetch chirp")
 		return
 	}
 
-	expires := time.Hour
-	if req.ExpiresInSeconds != nil {
-		requested := time.Duration(*req.ExpiresInSeconds) * time.Second
-		if requested < expires {
-			expires = requested
-		}
+	if chirp.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "forbidden")
+		return
 	}
 
-	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, expires)
+	updated, err := cfg.db.UpdateChirp(r.Context(), database.UpdateChirpParams{
+		ID:   chirpID,
+		Body: filter.Clean(req.Body, cfg.profaneWords),
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "could not create token")
+		respondWithDBError(w, err, "failed to update chirp")
 		return
 	}
+	// Re-index so the chirp is found by its new hashtags and mentions and
+	// no longer by the ones the edit removed.
+	if updated.Body != chirp.Body {
+		if err := cfg.db.DeleteChirpTags(r.Context(), chirpID); err != nil {
+			cfg.logger.Error("failed to clear chirp tags", "chirp_id", chirpID, "error", err)
+		}
+		if err := cfg.db.DeleteChirpMentions(r.Context(), chirpID); err != nil {
+			cfg.logger.Error("failed to clear chirp mentions", "chirp_id", chirpID, "error", err)
+		}
+		cfg.indexChirp(r.Context(), updated)
+	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	result, err := cfg.chirpWithLikes(r.Context(), updated)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to create refresh token")
+		respondWithDBError(w, err, "failed to count likes")
 		return
 	}
-	err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token:		refreshToken,
-		UserID:		uuid.NullUUID{UUID: user.ID, Valid: true},
-		ExpiresAt:	time.Now().Add(60 * 24 * time.Hour),
-	})
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// handleChirpLikes serves both POST (like) and DELETE (unlike).
+func (cfg *apiConfig) handleChirpLikes(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to store refresh token")
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"id":							user.ID,
-		"email":					user.Email,
-		"created_at":			user.CreatedAt,
-		"updated_at":			user.UpdatedAt,
-		"is_chirpy_red": user.IsChirpyRed,
-		"token":					token,
-		"refresh_token":	refreshToken,
-	})
-}
+	userID := userIDFromContext(r.Context())
 
-func (cfg *apiConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	if _, err := cfg.db.GetChirp(r.Context(), chirpID); err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to fetch chirp")
 		return
 	}
-	refreshToken, err := auth.GetBearerToken(r.Header)
+
+	if r.Method == http.MethodPost {
+		err = cfg.db.CreateLike(r.Context(), database.CreateLikeParams{
+			ChirpID: chirpID,
+			UserID:  userID,
+		})
+	} else {
+		err = cfg.db.DeleteLike(r.Context(), database.DeleteLikeParams{
+			ChirpID: chirpID,
+			UserID:  userID,
+		})
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "missing refresh token")
+		if isForeignKeyViolation(err) {
+			respondWithError(w, http.StatusUnauthorized, "user no longer exists")
+			return
+		}
+		respondWithDBError(w, err, "failed to update like")
 		return
 	}
-	user, err := cfg.db.GetUserFromRefreshToken(r.Context(), refreshToken)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handleChirpReplies(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid refresh token")
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
 		return
 	}
 
-	tokenRow, err := cfg.db.GetRefreshToken(r.Context(), refreshToken)
-	if err != nil || !tokenRow.RevokedAt.Valid && tokenRow.ExpiresAt.Before(time.Now()) {
-		respondWithError(w, http.StatusUnauthorized, "refresh token expired or revoked")
+	if _, err := cfg.db.GetChirp(r.Context(), chirpID); err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithDBError(w, err, "failed to fetch chirp")
 		return
 	}
 
-	newToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	replies, err := cfg.db.GetChirpReplies(r.Context(), uuid.NullUUID{UUID: chirpID, Valid: true})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "could not create access token")
+		respondWithDBError(w, err, "failed to fetch replies")
 		return
 	}
-	respondWithJSON(w, http.StatusOK, map[string]string{"token": newToken})
+
+	result := make([]Chirp, 0, len(replies))
+	for _, row := range replies {
+		result = append(result, chirpResponse(row.Chirp, row.Likes))
+	}
+	respondWithJSON(w, http.StatusOK, result)
 }
 
-func (cfg *apiConfig) handleRevoke(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// handleTagChirps lists chirps carrying a hashtag, newest first. The tag
+// may be given with or without its leading '#' and in any case.
+func (cfg *apiConfig) handleTagChirps(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(strings.TrimPrefix(r.PathValue("tag"), "#"))
+	if tags := extract.Hashtags("#" + tag); len(tags) != 1 || tags[0] != tag {
+		respondWithError(w, http.StatusBadRequest, "invalid tag")
 		return
 	}
 
-	refreshToken, err := auth.GetBearerToken(r.Header)
+	limit, offset, err := parsePagination(r.URL.Query())
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "missing refresh token")
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(r.Context(), database.RevokeRefreshTokenParams{
-		Token:     refreshToken,
-		RevokedAt: sql.NullTime{
-			Time:		time.Now(),
-			Valid:	true,
-		},
-		UpdatedAt: time.Now(),
+	chirps, err := cfg.db.GetChirpsByTag(r.Context(), database.GetChirpsByTagParams{
+		Tag:    tag,
+		Limit:  int32(limit),
+		Offset: int32(offset),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to revoke token")
+		respondWithDBError(w, err, "failed to fetch chirps")
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent) // 204
+	result := make([]Chirp, 0, len(chirps))
+	for _, row := range chirps {
+		result = append(result, chirpResponse(row.Chirp, row.Likes))
+	}
+	respondWithJSON(w, http.StatusOK, result)
 }
 
-func (cfg *apiConfig) handleChirps(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		defer r.Body.Close()
-
-		tokenString, err := auth.GetBearerToken(r.Header)
-		if err != nil {
-			respondWithError(w, http.StatusUnauthorized, "missing or invalid auth token")
-			return
-		}
-		userID, err := auth.ValidateJWT(tokenString, cfg.jwtSecret)
-		if err != nil {
-			respondWithError(w, http.StatusUnauthorized, "invalid token")
-			return
-		}
-		var req struct {
-			Body   string `json:"body"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			respondWithError(w, http.StatusBadRequest, "invalid request body")
-			return
-		}
+// handleLiveness reports that the process is up without touching any
+// dependency, so an orchestrator doesn't restart a pod waiting on the DB.
+func (cfg *apiConfig) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+}
 
-		if len(req.Body) > 140 {
-			respondWithError(w, http.StatusBadRequest, "chirp is too long")
-			return
-		}
+// handleHealthz is the readiness check under its older name, keeping the
+// {"status":"OK"} body existing load balancers expect.
+func (cfg *apiConfig) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !cfg.databaseReachable(w, r) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"status": "OK"})
+}
 
-		words := strings.Split(req.Body, " ")
-		profanity := map[string]bool{"kerfuffle": true, "sharbert": true, "fornax": true}
-		for i, word := range words {
-			if profanity[strings.ToLower(word)] {
-				words[i] = "****"
-			}
-		}
-		cleaned := strings.Join(words, " ")
+// handleReadiness reports whether the database is reachable.
+func (cfg *apiConfig) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	if !cfg.databaseReachable(w, r) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
 
-		chirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
-			Body:   cleaned,
-			UserID: userID,
+// databaseReachable pings the database, answering 503 itself and returning
+// false when the ping fails or takes longer than readinessTimeout.
+func (cfg *apiConfig) databaseReachable(w http.ResponseWriter, r *http.Request) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := cfg.sqlDB.PingContext(ctx); err != nil {
+		respondWithJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": "unavailable",
+			"error":  "database unreachable",
 		})
+		return false
+	}
+	return true
+}
+
+// handleMetrics renders the counters as HTML for browsers, or as JSON when
+// the client asks for application/json. A count that fails is shown as
+// unavailable (null in JSON) rather than failing the whole response.
+func (cfg *apiConfig) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	count := func(f func(context.Context) (int64, error)) *int64 {
+		n, err := f(r.Context())
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "failed to create chirp")
-			return
+			return nil
 		}
-
-		respondWithJSON(w, http.StatusCreated, Chirp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID,
+		return &n
+	}
+	hits := cfg.fileserverHits.Load()
+	chirps := count(cfg.db.CountChirps)
+	users := count(cfg.db.CountUsers)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		body := map[string]interface{}{
+			"fileserver_hits": hits,
+			"chirps":          chirps,
+			"users":           users,
+		}
+		cfg.counters.each(func(name string, n *atomic.Int64) {
+			body[name] = n.Load()
 		})
-	case http.MethodGet:
-		authorIDStr := r.URL.Query().Get("author_id")
-		sortOrder := r.URL.Query().Get("sort")
-		if sortOrder == "" {
-			sortOrder = "asc"
-		}
-
-		var chirps []database.Chirp
-		var err error
-		
-		if authorIDStr == "" {
-			chirps, err = cfg.db.GetChirps(r.Context())
-		} else {
-			authorID, parseErr := uuid.Parse(authorIDStr)
-			if parseErr != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			chirps, err = cfg.db.GetChirpsByAuthor(r.Context(), authorID)
+		respondWithJSON(w, http.StatusOK, body)
+		return
+	}
+
+	format := func(n *int64) string {
+		if n == nil {
+			return "unavailable"
 		}
+		return strconv.FormatInt(*n, 10)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, "<h1>Chirpy visited %d times</h1>", hits)
+	fmt.Fprintf(w, "<p>Chirps: %s</p><p>Users: %s</p>", format(chirps), format(users))
+	cfg.counters.each(func(name string, n *atomic.Int64) {
+		fmt.Fprintf(w, "<p>%s: %d</p>", name, n.Load())
+	})
+}
 
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "failed to fetch chirps")
-			return
-		}
-
-		sort.Slice(
-			chirps,
-			func(i, j int) bool {
-				if sortOrder == "desc" {
-					return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
-				}
-				return chirps[i].CreatedAt.Before(chirps[j].CreatedAt)
-			})
-
-		result := make([]Chirp, 0, len(chirps))
-		for _, c := range chirps {
-			result = append(result, Chirp{
-				ID:        c.ID,
-				CreatedAt: c.CreatedAt,
-				UpdatedAt: c.UpdatedAt,
-				Body:      c.Body,
-				UserID:    c.UserID,
-			})
-		}
-		respondWithJSON(w, http.StatusOK, result)
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// handleMetricsProm serves the same counters, plus request counts and
+// latencies when instrumented, in Prometheus exposition format. The
+// registry is built on the first scrape.
+func (cfg *apiConfig) handleMetricsProm(w http.ResponseWriter, r *http.Request) {
+	cfg.promOnce.Do(func() {
+		cfg.promHandler = promhttp.HandlerFor(cfg.newRegistry(), promhttp.HandlerOpts{})
+	})
+	cfg.promHandler.ServeHTTP(w, r)
+}
+
+// newRegistry returns a Prometheus registry that reads the fileserver hit
+// and endpoint counters at scrape time, plus requestMetrics when set.
+func (cfg *apiConfig) newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "chirpy_fileserver_hits_total",
+		Help: "Requests served by the /app/ file server.",
+	}, func() float64 {
+		return float64(cfg.fileserverHits.Load())
+	}))
+	cfg.counters.each(func(name string, n *atomic.Int64) {
+		reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "chirpy_" + name + "_total",
+			Help: "Total " + strings.ReplaceAll(name, "_", " ") + ".",
+		}, func() float64 {
+			return float64(n.Load())
+		}))
+	})
+	if cfg.requestMetrics != nil {
+		reg.MustRegister(cfg.requestMetrics)
 	}
+	return reg
 }
 
-func (cfg *apiConfig) handleChirpByID(w http.ResponseWriter, r *http.Request) {
-	idStr := strings.TrimPrefix(r.URL.Path, "/api/chirps/")
-	chirpID, err := uuid.Parse(idStr)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+func (cfg *apiConfig) handleReset(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != "dev" {
+		respondWithError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if err := cfg.db.DeleteAllUsers(r.Context()); err != nil {
+		respondWithDBError(w, err, "failed to delete users")
 		return
 	}
+	cfg.fileserverHits.Store(0)
+	cfg.counters.each(func(name string, n *atomic.Int64) {
+		n.Store(0)
+	})
+	w.WriteHeader(http.StatusOK)
+}
 
-	switch r.Method {
-	case http.MethodGet:
-		chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				respondWithError(w, http.StatusNotFound, "chirp not found")
-				return
-			}
-			respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp")
-			return
-		}
+// handleDeleteAllChirps wipes every chirp. Like handleReset, it only
+// works on the dev platform.
+func (cfg *apiConfig) handleDeleteAllChirps(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != "dev" {
+		respondWithError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if err := cfg.db.DeleteAllChirps(r.Context()); err != nil {
+		respondWithDBError(w, err, "failed to delete chirps")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
 
-		respondWithJSON(w, http.StatusOK, Chirp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID,
-		})
+// --- Main ---
 
-	case http.MethodDelete:
-		tokenString, err := auth.GetBearerToken(r.Header)
-		if err != nil {
-			respondWithError(w, http.StatusUnauthorized, "missing or invalid token")
-			return
-		}
-		userID, err := auth.ValidateJWT(tokenString, cfg.jwtSecret)
-		if err != nil {
-			respondWithError(w, http.StatusUnauthorized, "invalid token")
+// purgeDeletedChirps hard-deletes chirps whose restore window has passed.
+func (cfg *apiConfig) purgeDeletedChirps(ctx context.Context) (int64, error) {
+	cutoff := time.Now().Add(-cfg.chirpRestoreWindow)
+	return cfg.db.PurgeDeletedChirps(ctx, sql.NullTime{Time: cutoff, Valid: true})
+}
+
+// runChirpPurge calls purgeDeletedChirps every interval until ctx is done.
+func (cfg *apiConfig) runChirpPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
 			return
-		}
-		chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				respondWithError(w, http.StatusNotFound, "chirp not found")
-				return
+		case <-ticker.C:
+			purged, err := cfg.purgeDeletedChirps(ctx)
+			if err != nil {
+				cfg.logger.Error("purging deleted chirps failed", "error", err)
+				continue
+			}
+			if purged > 0 {
+				cfg.logger.Info("purged deleted chirps", "count", purged)
 			}
-			respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp")
-			return
-		}
-		
-		if chirp.UserID != userID {
-			respondWithError(w, http.StatusForbidden, "forbidden")
-			return
 		}
+	}
+}
 
-		if err := cfg.db.DeleteChirp(r.Context(), chirpID); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "failed to delete chirp")
-			return
-		}
+// routes registers every endpoint. The mux matches on method and path, so
+// handlers don't check r.Method and unsupported methods get a 405. Routes
+// that act for a signed-in user are wrapped in middlewareAuth.
+func (cfg *apiConfig) routes() *http.ServeMux {
+	mux := http.NewServeMux()
 
-		w.WriteHeader(http.StatusNoContent)
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.handlePolkaWebhook)
+	mux.Handle("POST /api/users", cfg.middlewareRateLimit(cfg.authLimiter, http.HandlerFunc(cfg.handleCreateUser)))
+	mux.Handle("PUT /api/users", cfg.middlewareAuth(http.HandlerFunc(cfg.handleUpdateUser)))
+	mux.Handle("PATCH /api/users", cfg.middlewareAuth(http.HandlerFunc(cfg.handleUpdateUser)))
+	mux.Handle("DELETE /api/users", cfg.middlewareAuth(http.HandlerFunc(cfg.handleDeleteUser)))
+	mux.Handle("GET /api/me", cfg.middlewareAuth(http.HandlerFunc(cfg.handleMe)))
+	mux.Handle("GET /api/users/me", cfg.middlewareAuth(http.HandlerFunc(cfg.handleMe)))
+	mux.Handle("GET /api/users/me/mentions", cfg.middlewareAuth(http.HandlerFunc(cfg.handleMentions)))
+	mux.HandleFunc("GET /api/users/{userID}", cfg.handleGetUser)
+	mux.Handle("POST /api/users/{userID}/follow", cfg.middlewareAuth(http.HandlerFunc(cfg.handleFollow)))
+	mux.Handle("DELETE /api/users/{userID}/follow", cfg.middlewareAuth(http.HandlerFunc(cfg.handleFollow)))
+	mux.Handle("GET /api/feed", cfg.middlewareAuth(http.HandlerFunc(cfg.handleFeed)))
+	mux.Handle("POST /api/login", cfg.middlewareRateLimit(cfg.loginLimiter, http.HandlerFunc(cfg.handleLogin)))
+	mux.HandleFunc("POST /api/verify", cfg.handleVerify)
+	mux.Handle("POST /api/chirps", cfg.middlewareAuth(http.HandlerFunc(cfg.handleCreateChirp)))
+	mux.Handle("POST /api/chirps/bulk", cfg.middlewareAuth(http.HandlerFunc(cfg.handleBulkCreateChirps)))
+	mux.HandleFunc("GET /api/chirps", cfg.handleListChirps)
+	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.handleGetChirp)
+	mux.Handle("PUT /api/chirps/{chirpID}", cfg.middlewareAuth(http.HandlerFunc(cfg.handleUpdateChirp)))
+	mux.Handle("DELETE /api/chirps/{chirpID}", cfg.middlewareAuth(http.HandlerFunc(cfg.handleDeleteChirp)))
+	mux.Handle("DELETE /api/chirps/mine", cfg.middlewareAuth(http.HandlerFunc(cfg.handleDeleteMyChirps)))
+	mux.Handle("POST /api/chirps/{chirpID}/restore", cfg.middlewareAuth(http.HandlerFunc(cfg.handleRestoreChirp)))
+	mux.Handle("POST /api/chirps/{chirpID}/likes", cfg.middlewareAuth(http.HandlerFunc(cfg.handleChirpLikes)))
+	mux.Handle("DELETE /api/chirps/{chirpID}/likes", cfg.middlewareAuth(http.HandlerFunc(cfg.handleChirpLikes)))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/replies", cfg.handleChirpReplies)
+	mux.HandleFunc("GET /api/tags/{tag}/chirps", cfg.handleTagChirps)
+	mux.Handle("POST /api/refresh", cfg.middlewareRateLimit(cfg.authLimiter, http.HandlerFunc(cfg.handleRefresh)))
+	mux.HandleFunc("POST /api/password_reset", cfg.handlePasswordReset)
+	mux.HandleFunc("POST /api/password_reset/confirm", cfg.handlePasswordResetConfirm)
+	mux.HandleFunc("POST /api/revoke", cfg.handleRevoke)
+	mux.Handle("POST /api/logout_all", cfg.middlewareAuth(http.HandlerFunc(cfg.handleLogoutAll)))
+	mux.Handle("POST /api/revoke-all", cfg.middlewareAuth(http.HandlerFunc(cfg.handleLogoutAll)))
+	mux.Handle("DELETE /api/revoke-all", cfg.middlewareAuth(http.HandlerFunc(cfg.handleLogoutAll)))
+	mux.Handle("GET /api/sessions", cfg.middlewareAuth(http.HandlerFunc(cfg.handleListSessions)))
+	mux.Handle("DELETE /api/sessions", cfg.middlewareAuth(http.HandlerFunc(cfg.handleLogoutAll)))
 
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
+	// Health & admin
+	mux.HandleFunc("GET /api/healthz", cfg.handleHealthz)
+	mux.HandleFunc("GET /api/livez", cfg.handleLiveness)
+	mux.HandleFunc("GET /api/readyz", cfg.handleReadiness)
+	mux.Handle("GET /metrics", cfg.middlewareMetrics(http.HandlerFunc(cfg.handleMetricsProm)))
+	mux.Handle("GET /admin/metrics", cfg.middlewareAdmin(http.HandlerFunc(cfg.handleMetrics)))
+	mux.Handle("GET /admin/metrics.prom", cfg.middlewareAdmin(http.HandlerFunc(cfg.handleMetricsProm)))
+	mux.Handle("POST /admin/reset", cfg.middlewareAdmin(http.HandlerFunc(cfg.handleReset)))
+	mux.Handle("DELETE /admin/chirps", cfg.middlewareAdmin(http.HandlerFunc(cfg.handleDeleteAllChirps)))
+
+	fileServer := cfg.middlewareMetricsInc(http.FileServer(http.Dir(".")))
+	mux.Handle("/app/", http.StripPrefix("/app", fileServer))
+	mux.Handle("/", notFound(mux))
+
+	return mux
+}
+
+// notFound answers requests no other route matches with a JSON error.
+// Paths that exist under another method still get a 405 with an Allow
+// header. /app/ has its own catch-all, so the file server keeps its 404s.
+func notFound(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != "" && pattern != "/" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		respondWithError(w, http.StatusNotFound, "not found")
+	})
 }
 
-// --- Main ---
 
 func main() {
 	if err := godotenv.Load(); err != nil {
@@ -508,65 +2676,100 @@ func main() {
 	if polkaKey == "" {
 		log.Fatal("POLKA_KEY not set")
 	}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: envLogLevel("LOG_LEVEL"),
+	}))
+	slog.SetDefault(logger)
+
 	dbURL := os.Getenv("DB_URL")
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
 
-	dbQueries := database.New(db)
+	// BCRYPT_COST keeps the name deployments already set, but passwords are
+	// hashed with argon2id: it sets the iteration count, clamped to
+	// auth.MinPasswordCost..auth.MaxPasswordCost. PASSWORD_HASH_COST is
+	// accepted as an alias.
+	cost := envInt("BCRYPT_COST", 0)
+	if cost == 0 {
+		cost = envInt("PASSWORD_HASH_COST", 0)
+	}
+	if cost != 0 {
+		auth.SetPasswordCost(cost)
+	}
+
+	if os.Getenv("ADMIN_TOKEN") == "" {
+		logger.Warn("ADMIN_TOKEN not set, admin endpoints will reject every request")
+	}
+
+	queryTimeout := envDuration("DB_QUERY_TIMEOUT", defaultDBQueryTimeout)
+	dbQueries := database.WithTimeout(database.New(db), queryTimeout)
 	cfg := &apiConfig{
 		db:					dbQueries,
+		sqlDB:				db,
+		createChirpsTx:	func(ctx context.Context, params []database.CreateChirpParams) ([]database.Chirp, error) {
+			ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+			defer cancel()
+			return database.CreateChirpsTx(ctx, db, params)
+		},
 		platform:		os.Getenv("PLATFORM"),
 		jwtSecret:	jwtSecret,
+		accessTokenTTL:	envDuration("ACCESS_TOKEN_TTL", defaultAccessTokenTTL),
+		chirpRestoreWindow:	envDuration("CHIRP_RESTORE_WINDOW", defaultChirpRestoreWindow),
 		polkaKey:		polkaKey,
+		polkaWebhookSecret:	os.Getenv("POLKA_WEBHOOK_SECRET"),
+		adminToken:			os.Getenv("ADMIN_TOKEN"),
+		metricsToken:		os.Getenv("METRICS_TOKEN"),
+		profaneWords:	parseProfaneWords(os.Getenv("PROFANE_WORDS")),
+		minPasswordLength:	envInt("MIN_PASSWORD_LENGTH", defaultMinPasswordLength),
+		maxChirpLength:	envInt("MAX_CHIRP_LENGTH", defaultMaxChirpLength),
+		chirpRateLimit:	envInt("CHIRP_RATE_LIMIT", defaultChirpRateLimit),
+		chirpRateWindow:	envDuration("CHIRP_RATE_WINDOW", defaultChirpRateWindow),
+		now:						time.Now,
+		requireMixedPassword:	os.Getenv("PASSWORD_REQUIRE_MIXED") == "true",
+		// Tokens only reach users through the dev log until mail delivery
+		// exists, so requiring verification elsewhere is opt-in.
+		requireVerification:	os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+		logger:					logger,
+		corsOrigins:		parseOrigins(os.Getenv("CORS_ORIGINS")),
+		authLimiter:		newLimiter(envInt("AUTH_RATE_LIMIT_PER_MINUTE", defaultAuthRateLimit), time.Minute),
+		loginLimiter:		newLimiter(
+			envInt("LOGIN_RATE_LIMIT", defaultLoginRateLimit),
+			envDuration("LOGIN_RATE_WINDOW", time.Minute),
+		),
+		trustProxy:			os.Getenv("TRUST_PROXY") == "true",
+		requestMetrics:	metrics.NewRequests(prometheus.DefBuckets),
 	}
 
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/api/polka/webhooks", cfg.handlePolkaWebhook)
-	mux.HandleFunc("/api/users", cfg.handleUsers)
-	mux.HandleFunc("/api/login", cfg.handleLogin)
-	mux.HandleFunc("/api/chirps", cfg.handleChirps)
-	mux.HandleFunc("/api/chirps/", cfg.handleChirpByID)
-	mux.HandleFunc("/api/refresh", cfg.handleRefresh)
-	mux.HandleFunc("/api/revoke", cfg.handleRevoke)
-
-
-	// Health & admin
-	mux.HandleFunc("/api/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"OK"}`))
-	})
-
-	mux.HandleFunc("/admin/metrics", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, "<h1>Chirpy visited %d times</h1>", cfg.fileserverHits.Load())
-	})
+	server := newServer(":8080", middlewareRequestID(cfg.middlewareLog(cfg.middlewareCORS(cfg.middlewareInstrument(cfg.routes())))))
 
-	mux.HandleFunc("/admin/reset", func(w http.ResponseWriter, r *http.Request) {
-		if cfg.platform != "dev" {
-			respondWithError(w, http.StatusForbidden, "forbidden")
-			return
-		}
-		if err := cfg.db.DeleteAllUsers(r.Context()); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "failed to delete users")
-			return
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for _, limiter := range []*ratelimit.Limiter{cfg.authLimiter, cfg.loginLimiter} {
+		if limiter != nil {
+			go limiter.Run(ctx, time.Minute)
 		}
-		cfg.fileserverHits.Store(0)
-		w.WriteHeader(http.StatusOK)
-	})
+	}
+	go cfg.runChirpPurge(ctx, chirpPurgeInterval)
 
-	fileServer := cfg.middlewareMetricsInc(http.FileServer(http.Dir(".")))
-	mux.Handle("/app/", http.StripPrefix("/app", fileServer))
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", "http://localhost:8080")
+		serverErr <- server.ListenAndServe()
+	}()
 
-	server := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+	select {
+	case err := <-serverErr:
+		db.Close()
+		log.Fatal(err)
+	case <-ctx.Done():
+		logger.Info("received shutdown signal")
 	}
+	stop()
 
-	log.Println("Listening on http://localhost:8080")
-	log.Fatal(server.ListenAndServe())
+	if err := shutdown(context.Background(), server, db, shutdownTimeout); err != nil {
+		log.Fatal(err)
+	}
+	logger.Info("shutdown complete")
 }
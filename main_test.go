@@ -0,0 +1,4022 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NebojsaJovanovic95/chirpy/internal/auth"
+	"github.com/NebojsaJovanovic95/chirpy/internal/database"
+	"github.com/NebojsaJovanovic95/chirpy/internal/metrics"
+	"github.com/NebojsaJovanovic95/chirpy/internal/ratelimit"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const testJWTSecret = "test-secret"
+
+const testAdminToken = "test-admin-token"
+
+func newTestConfig() (*apiConfig, *fakeDB) {
+	db := newFakeDB()
+	cfg := &apiConfig{
+		db:                  db,
+		createChirpsTx:      db.createChirpsTx,
+		platform:            "dev",
+		jwtSecret:           testJWTSecret,
+		accessTokenTTL:      defaultAccessTokenTTL,
+		chirpRestoreWindow:  defaultChirpRestoreWindow,
+		polkaKey:            "test-polka-key",
+		adminToken:          testAdminToken,
+		profaneWords:        parseProfaneWords(""),
+		minPasswordLength:   defaultMinPasswordLength,
+		maxChirpLength:      defaultMaxChirpLength,
+		now:                 time.Now,
+		requireVerification: true,
+		logger:              slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	return cfg, db
+}
+
+func makeTestToken(t *testing.T, userID uuid.UUID) string {
+	t.Helper()
+	token, err := auth.MakeJWT(userID, testJWTSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+	return token
+}
+
+// newVerifiedUser stores a user that is allowed to post chirps.
+func newVerifiedUser(t *testing.T, db *fakeDB, email string) database.User {
+	t.Helper()
+	user, err := db.CreateUser(context.Background(), email)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	db.MarkUserVerified(context.Background(), user.ID)
+	return user
+}
+
+// loggedToken returns the token attribute of the JSON log line with message
+// msg, the way the dev platform surfaces tokens until mail delivery exists.
+func loggedToken(t *testing.T, logs, msg string) string {
+	t.Helper()
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err == nil && entry["msg"] == msg {
+			if token, ok := entry["token"].(string); ok && token != "" {
+				return token
+			}
+		}
+	}
+	t.Fatalf("no %q log line with a token in %q", msg, logs)
+	return ""
+}
+
+func decodeChirps(t *testing.T, rec *httptest.ResponseRecorder) []Chirp {
+	t.Helper()
+	var chirps []Chirp
+	if err := json.NewDecoder(rec.Body).Decode(&chirps); err != nil {
+		t.Fatalf("failed to decode chirps: %v", err)
+	}
+	return chirps
+}
+
+func chirpIDs(chirps []Chirp) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(chirps))
+	for _, c := range chirps {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []uuid.UUID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGetChirpsSort(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := uuid.New()
+	other := uuid.New()
+	first, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "first", UserID: author})
+	second, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "second", UserID: other})
+	third, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "third", UserID: author})
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []uuid.UUID
+	}{
+		{"missing defaults to asc", "", http.StatusOK, []uuid.UUID{first.ID, second.ID, third.ID}},
+		{"asc", "?sort=asc", http.StatusOK, []uuid.UUID{first.ID, second.ID, third.ID}},
+		{"desc", "?sort=desc", http.StatusOK, []uuid.UUID{third.ID, second.ID, first.ID}},
+		{"asc with author", "?sort=asc&author_id=" + author.String(), http.StatusOK, []uuid.UUID{first.ID, third.ID}},
+		{"desc with author", "?sort=desc&author_id=" + author.String(), http.StatusOK, []uuid.UUID{third.ID, first.ID}},
+		{"garbage with author", "?sort=sideways&author_id=" + author.String(), http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/chirps"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := chirpIDs(decodeChirps(t, rec)); !equalIDs(got, tt.wantIDs) {
+				t.Errorf("expected order %v, got %v", tt.wantIDs, got)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "user@example.com")
+	userID := uuid.NullUUID{UUID: user.ID, Valid: true}
+
+	db.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		Token: auth.HashToken("valid"), UserID: userID, ExpiresAt: time.Now().Add(time.Hour),
+	})
+	db.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		Token: auth.HashToken("expired"), UserID: userID, ExpiresAt: time.Now().Add(-time.Hour),
+	})
+	db.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		Token: auth.HashToken("revoked"), UserID: userID, ExpiresAt: time.Now().Add(time.Hour),
+	})
+	db.RevokeRefreshToken(context.Background(), database.RevokeRefreshTokenParams{
+		Token: auth.HashToken("revoked"), RevokedAt: sql.NullTime{Time: time.Now(), Valid: true}, UpdatedAt: time.Now(),
+	})
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		wantError  string
+	}{
+		{"valid", "valid", http.StatusOK, ""},
+		{"expired not revoked", "expired", http.StatusUnauthorized, "refresh token expired"},
+		{"revoked not expired", "revoked", http.StatusUnauthorized, "refresh token revoked"},
+		{"unknown", "unknown", http.StatusUnauthorized, "invalid refresh token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			cfg.handleRefresh(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantError != "" {
+				var body map[string]string
+				json.NewDecoder(rec.Body).Decode(&body)
+				if body["error"] != tt.wantError {
+					t.Fatalf("expected error %q, got %q", tt.wantError, body["error"])
+				}
+			}
+		})
+	}
+}
+
+func TestGetChirpsPagination(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := uuid.New()
+	var all []uuid.UUID
+	for i := 0; i < maxPageLimit+5; i++ {
+		c, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "chirp", UserID: author})
+		all = append(all, c.ID)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []uuid.UUID
+	}{
+		{"default limit", "", http.StatusOK, all[:defaultPageLimit]},
+		{"limit capped", "?limit=1000", http.StatusOK, all[:maxPageLimit]},
+		{"limit and offset", "?limit=3&offset=4", http.StatusOK, all[4:7]},
+		{"offset past end", "?offset=1000", http.StatusOK, []uuid.UUID{}},
+		{"non-integer limit", "?limit=ten", http.StatusBadRequest, nil},
+		{"negative limit", "?limit=-1", http.StatusBadRequest, nil},
+		{"negative offset", "?offset=-1", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/chirps"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := chirpIDs(decodeChirps(t, rec)); !equalIDs(got, tt.wantIDs) {
+				t.Errorf("expected %d chirps %v, got %d %v", len(tt.wantIDs), tt.wantIDs, len(got), got)
+			}
+		})
+	}
+}
+
+func TestUpdateChirp(t *testing.T) {
+	cfg, db := newTestConfig()
+	owner := uuid.New()
+	chirp, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "original", UserID: owner})
+
+	tests := []struct {
+		name       string
+		chirpID    uuid.UUID
+		userID     uuid.UUID
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"owner edits", chirp.ID, owner, `{"body":"edited kerfuffle"}`, http.StatusOK, "edited ****"},
+		{"not owner", chirp.ID, uuid.New(), `{"body":"hijacked"}`, http.StatusForbidden, ""},
+		{"too long", chirp.ID, owner, `{"body":"` + strings.Repeat("a", defaultMaxChirpLength+1) + `"}`, http.StatusBadRequest, ""},
+		{"missing chirp", uuid.New(), owner, `{"body":"edited"}`, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/api/chirps/"+tt.chirpID.String(), strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+makeTestToken(t, tt.userID))
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got Chirp
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode chirp: %v", err)
+			}
+			if got.Body != tt.wantBody {
+				t.Errorf("expected body %q, got %q", tt.wantBody, got.Body)
+			}
+			if !got.UpdatedAt.After(chirp.UpdatedAt) {
+				t.Errorf("expected updated_at to move past %v, got %v", chirp.UpdatedAt, got.UpdatedAt)
+			}
+		})
+	}
+
+	stored, _ := db.GetChirp(context.Background(), chirp.ID)
+	if stored.Body != "edited ****" {
+		t.Errorf("expected stored body to be edited, got %q", stored.Body)
+	}
+}
+
+func TestParseProfaneWords(t *testing.T) {
+	defaults := parseProfaneWords("")
+	for _, word := range defaultProfaneWords {
+		if !defaults[word] {
+			t.Errorf("expected default word %q in set", word)
+		}
+	}
+
+	custom := parseProfaneWords(" Darn, heck ,,")
+	if len(custom) != 2 || !custom["darn"] || !custom["heck"] {
+		t.Errorf("unexpected custom word set: %v", custom)
+	}
+}
+
+func TestCreateChirpCustomProfanity(t *testing.T) {
+	cfg, db := newTestConfig()
+	cfg.profaneWords = parseProfaneWords("darn,heck")
+	token := makeTestToken(t, newVerifiedUser(t, db, "polite@example.com").ID)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"custom word", "oh darn it", "oh **** it"},
+		{"case insensitive", "HECK yes", "**** yes"},
+		{"default no longer applies", "what a kerfuffle", "what a kerfuffle"},
+		{"punctuation aware", "darn! darnation", "****! darnation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(map[string]string{"body": tt.body})
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(string(payload)))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+			}
+			var got Chirp
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode chirp: %v", err)
+			}
+			if got.Body != tt.want {
+				t.Errorf("expected body %q, got %q", tt.want, got.Body)
+			}
+		})
+	}
+}
+
+func TestSearchChirps(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := uuid.New()
+	other := uuid.New()
+	hello, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "Hello world", UserID: author})
+	otherHello, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "hello from someone else", UserID: other})
+	percent, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "giving 100% today", UserID: author})
+	db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "snake_case is fine", UserID: author})
+
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []uuid.UUID
+	}{
+		{"hit is case-insensitive", "?q=HELLO", []uuid.UUID{hello.ID, otherHello.ID}},
+		{"hit with author and sort", "?q=hello&sort=desc&author_id=" + author.String(), []uuid.UUID{hello.ID}},
+		{"miss", "?q=goodbye", []uuid.UUID{}},
+		{"percent matches literally", "?q=" + url.QueryEscape("0%"), []uuid.UUID{percent.ID}},
+		{"lone percent is not a wildcard", "?q=" + url.QueryEscape("%"), []uuid.UUID{percent.ID}},
+		{"underscore is not a wildcard", "?q=o_d", []uuid.UUID{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/chirps"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+			}
+			if got := chirpIDs(decodeChirps(t, rec)); !equalIDs(got, tt.wantIDs) {
+				t.Errorf("expected %v, got %v", tt.wantIDs, got)
+			}
+		})
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`50%_off\`); got != `50\%\_off\\` {
+		t.Errorf("unexpected escape result %q", got)
+	}
+}
+
+func TestUpdateChirpRequiresToken(t *testing.T) {
+	cfg, db := newTestConfig()
+	chirp, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "original", UserID: uuid.New()})
+
+	req := httptest.NewRequest(http.MethodPut, "/api/chirps/"+chirp.ID.String(), strings.NewReader(`{"body":"edited"}`))
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	if stored, _ := db.GetChirp(context.Background(), chirp.ID); stored.Body != "original" {
+		t.Errorf("expected chirp to be unchanged, got %q", stored.Body)
+	}
+}
+
+func TestGetUserByID(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "someone@example.com")
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+	}{
+		{"found", user.ID.String(), http.StatusOK},
+		{"missing", uuid.New().String(), http.StatusNotFound},
+		{"malformed", "not-a-uuid", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/users/"+tt.id, nil)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode user: %v", err)
+			}
+			if got["id"] != user.ID.String() || got["email"] != user.Email {
+				t.Errorf("unexpected user payload %v", got)
+			}
+			if _, ok := got["hashed_password"]; ok {
+				t.Errorf("hashed password must not be exposed")
+			}
+		})
+	}
+}
+
+func TestChirpLikes(t *testing.T) {
+	cfg, db := newTestConfig()
+	chirp, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "like me", UserID: uuid.New()})
+	aliceUser, _ := db.CreateUser(context.Background(), "alice@example.com")
+	bobUser, _ := db.CreateUser(context.Background(), "bob@example.com")
+	alice := aliceUser.ID
+	bob := bobUser.ID
+
+	like := func(method string, userID, chirpID uuid.UUID) int {
+		req := httptest.NewRequest(method, "/api/chirps/"+chirpID.String()+"/likes", nil)
+		req.Header.Set("Authorization", "Bearer "+makeTestToken(t, userID))
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	likesInGet := func() int64 {
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps/"+chirp.ID.String(), nil)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		var got Chirp
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode chirp: %v", err)
+		}
+		return got.Likes
+	}
+
+	if code := like(http.MethodPost, alice, chirp.ID); code != http.StatusNoContent {
+		t.Fatalf("expected like to return %d, got %d", http.StatusNoContent, code)
+	}
+	if code := like(http.MethodPost, alice, chirp.ID); code != http.StatusNoContent {
+		t.Fatalf("expected repeated like to return %d, got %d", http.StatusNoContent, code)
+	}
+	like(http.MethodPost, bob, chirp.ID)
+	if got := likesInGet(); got != 2 {
+		t.Fatalf("expected 2 likes after double like, got %d", got)
+	}
+
+	if code := like(http.MethodDelete, alice, chirp.ID); code != http.StatusNoContent {
+		t.Fatalf("expected unlike to return %d, got %d", http.StatusNoContent, code)
+	}
+	if got := likesInGet(); got != 1 {
+		t.Fatalf("expected 1 like after unlike, got %d", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if listed := decodeChirps(t, rec); len(listed) != 1 || listed[0].Likes != 1 {
+		t.Fatalf("expected the list to report 1 like, got %+v", listed)
+	}
+
+	if code := like(http.MethodPost, alice, uuid.New()); code != http.StatusNotFound {
+		t.Fatalf("expected liking a missing chirp to return %d, got %d", http.StatusNotFound, code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/chirps/"+chirp.ID.String()+"/likes", nil)
+	rec = httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthenticated like to return %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "leaving@example.com")
+	other, _ := db.CreateUser(context.Background(), "staying@example.com")
+	db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "bye", UserID: user.ID})
+	kept, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "still here", UserID: other.ID})
+	db.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		Token: auth.HashToken("leaving-refresh"), UserID: uuid.NullUUID{UUID: user.ID, Valid: true}, ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	deleteUser := func() int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/users", nil)
+		req.Header.Set("Authorization", "Bearer "+makeTestToken(t, user.ID))
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := deleteUser(); code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, code)
+	}
+
+	if remaining, _ := db.CountChirpsByAuthor(context.Background(), user.ID); remaining != 0 {
+		t.Errorf("expected deleted user's chirps to be gone, found %d", remaining)
+	}
+	if _, err := db.GetChirp(context.Background(), kept.ID); err != nil {
+		t.Errorf("expected other user's chirp to remain: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer leaving-refresh")
+	rec := httptest.NewRecorder()
+	cfg.handleRefresh(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected deleted user's refresh token to be rejected, got %d", rec.Code)
+	}
+
+	if code := deleteUser(); code != http.StatusNotFound {
+		t.Fatalf("expected second delete to return %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestDeletedUserToken(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "gone@example.com")
+	other, _ := db.CreateUser(context.Background(), "here@example.com")
+	chirp, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "like me", UserID: other.ID})
+	token := makeTestToken(t, user.ID)
+	db.DeleteUser(context.Background(), user.ID)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"update user", http.MethodPut, "/api/users", `{"bio":"still here?"}`},
+		{"like chirp", http.MethodPost, "/api/chirps/" + chirp.ID.String() + "/likes", ""},
+		{"follow user", http.MethodPost, "/api/users/" + other.ID.String() + "/follow", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusUnauthorized, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestChirpReplies(t *testing.T) {
+	cfg, db := newTestConfig()
+	parent, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "top level", UserID: uuid.New()})
+	token := makeTestToken(t, newVerifiedUser(t, db, "replier@example.com").ID)
+
+	postChirp := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	var replyIDs []uuid.UUID
+	for _, body := range []string{"first reply", "second reply"} {
+		rec := postChirp(`{"body":"` + body + `","parent_id":"` + parent.ID.String() + `"}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+		}
+		var reply Chirp
+		if err := json.NewDecoder(rec.Body).Decode(&reply); err != nil {
+			t.Fatalf("failed to decode reply: %v", err)
+		}
+		if reply.ParentID == nil || *reply.ParentID != parent.ID {
+			t.Fatalf("expected parent_id %v, got %v", parent.ID, reply.ParentID)
+		}
+		replyIDs = append(replyIDs, reply.ID)
+	}
+
+	if rec := postChirp(`{"body":"orphan","parent_id":"` + uuid.New().String() + `"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected bogus parent to return %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec := postChirp(`{"body":"no parent"}`)
+	var top map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&top)
+	if _, ok := top["parent_id"]; ok {
+		t.Errorf("expected parent_id to be omitted for top-level chirps")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/"+parent.ID.String()+"/replies", nil)
+	rec = httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if got := chirpIDs(decodeChirps(t, rec)); !equalIDs(got, replyIDs) {
+		t.Errorf("expected replies %v, got %v", replyIDs, got)
+	}
+}
+
+func TestFollowAndFeed(t *testing.T) {
+	cfg, db := newTestConfig()
+	reader, _ := db.CreateUser(context.Background(), "reader@example.com")
+	alice, _ := db.CreateUser(context.Background(), "alice@example.com")
+	bob, _ := db.CreateUser(context.Background(), "bob@example.com")
+	carol, _ := db.CreateUser(context.Background(), "carol@example.com")
+	a1, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "alice 1", UserID: alice.ID})
+	b1, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "bob 1", UserID: bob.ID})
+	db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "carol 1", UserID: carol.ID})
+	a2, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "alice 2", UserID: alice.ID})
+	token := makeTestToken(t, reader.ID)
+
+	follow := func(method string, followee uuid.UUID) int {
+		req := httptest.NewRequest(method, "/api/users/"+followee.String()+"/follow", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	feed := func() []uuid.UUID {
+		req := httptest.NewRequest(http.MethodGet, "/api/feed", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected feed status %d, got %d", http.StatusOK, rec.Code)
+		}
+		return chirpIDs(decodeChirps(t, rec))
+	}
+
+	for _, followee := range []uuid.UUID{alice.ID, bob.ID} {
+		if code := follow(http.MethodPost, followee); code != http.StatusNoContent {
+			t.Fatalf("expected follow to return %d, got %d", http.StatusNoContent, code)
+		}
+	}
+	if got, want := feed(), []uuid.UUID{a2.ID, b1.ID, a1.ID}; !equalIDs(got, want) {
+		t.Fatalf("expected feed %v, got %v", want, got)
+	}
+
+	if code := follow(http.MethodDelete, bob.ID); code != http.StatusNoContent {
+		t.Fatalf("expected unfollow to return %d, got %d", http.StatusNoContent, code)
+	}
+	if got, want := feed(), []uuid.UUID{a2.ID, a1.ID}; !equalIDs(got, want) {
+		t.Fatalf("expected feed without bob %v, got %v", want, got)
+	}
+
+	if code := follow(http.MethodPost, reader.ID); code != http.StatusBadRequest {
+		t.Errorf("expected self-follow to return %d, got %d", http.StatusBadRequest, code)
+	}
+	if code := follow(http.MethodPost, uuid.New()); code != http.StatusNotFound {
+		t.Errorf("expected following a missing user to return %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	cfg, db := newTestConfig()
+	existing, _ := db.CreateUser(context.Background(), "existing@example.com")
+
+	tests := []struct {
+		name       string
+		password   string
+		wantStatus int
+	}{
+		{"empty", "", http.StatusBadRequest},
+		{"too short", "short", http.StatusBadRequest},
+		{"acceptable", "long-enough", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(map[string]string{"email": tt.name + "@example.com", "password": tt.password})
+			req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(string(payload)))
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusBadRequest {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected JSON error, got content type %q", ct)
+			}
+			var body struct {
+				Error  string            `json:"error"`
+				Fields map[string]string `json:"fields"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error == "" || body.Fields["password"] == "" {
+				t.Errorf("expected JSON error body naming the password, got err=%v body=%+v", err, body)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(`{"email":"existing@example.com","password":"short"}`))
+	req.Header.Set("Authorization", "Bearer "+makeTestToken(t, existing.ID))
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected short password on update to return %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestUpdateUserPartial(t *testing.T) {
+	cfg, db := newTestConfig()
+	hash, _ := auth.HashPassword("original-pass")
+	created, _ := db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{Email: "partial@example.com", HashedPassword: hash})
+	token := makeTestToken(t, created.ID)
+
+	updateWith := func(method, payload string) int {
+		req := httptest.NewRequest(method, "/api/users", strings.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	update := func(payload string) int {
+		return updateWith(http.MethodPut, payload)
+	}
+
+	if code := update(`{"email":"renamed@example.com"}`); code != http.StatusOK {
+		t.Fatalf("expected email-only update to return %d, got %d", http.StatusOK, code)
+	}
+	user := db.users[created.ID]
+	if user.Email != "renamed@example.com" {
+		t.Errorf("expected email to change, got %q", user.Email)
+	}
+	if user.HashedPassword != hash {
+		t.Errorf("expected email-only update to keep the password hash")
+	}
+
+	if code := update(`{"password":"brand-new-pass"}`); code != http.StatusOK {
+		t.Fatalf("expected password-only update to return %d, got %d", http.StatusOK, code)
+	}
+	user = db.users[created.ID]
+	if user.Email != "renamed@example.com" {
+		t.Errorf("expected password-only update to keep the email, got %q", user.Email)
+	}
+	if ok, err := auth.CheckPasswordHash("brand-new-pass", user.HashedPassword); err != nil || !ok {
+		t.Errorf("expected the new password to be stored: ok=%v err=%v", ok, err)
+	}
+
+	if code := updateWith(http.MethodPatch, `{"email":"both@example.com","password":"third-pass-here"}`); code != http.StatusOK {
+		t.Fatalf("expected both-fields PATCH to return %d, got %d", http.StatusOK, code)
+	}
+	user = db.users[created.ID]
+	if user.Email != "both@example.com" {
+		t.Errorf("expected both-fields update to change the email, got %q", user.Email)
+	}
+	if ok, _ := auth.CheckPasswordHash("third-pass-here", user.HashedPassword); !ok {
+		t.Errorf("expected both-fields update to change the password")
+	}
+
+	for _, payload := range []string{`{}`, `{"email":"","password":""}`, `{"email":""}`, `{"password":""}`, `{"email":null}`} {
+		if code := update(payload); code != http.StatusBadRequest {
+			t.Errorf("expected %s to return %d, got %d", payload, http.StatusBadRequest, code)
+		}
+	}
+	if user := db.users[created.ID]; user.Email != "both@example.com" {
+		t.Errorf("expected rejected updates to keep the email, got %q", user.Email)
+	}
+}
+
+func TestUpdateUserAvatarURL(t *testing.T) {
+	cfg, db := newTestConfig()
+	hash, _ := auth.HashPassword("avatar-pass")
+	created, _ := db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{Email: "avatar@example.com", HashedPassword: hash})
+	token := makeTestToken(t, created.ID)
+
+	update := func(payload string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	const avatar = "https://cdn.example.com/avatars/me.png"
+	rec := update(`{"avatar_url":"` + avatar + `"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected avatar update to return %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var updated struct {
+		AvatarURL string `json:"avatar_url"`
+	}
+	json.NewDecoder(rec.Body).Decode(&updated)
+	if updated.AvatarURL != avatar {
+		t.Errorf("expected response avatar_url %q, got %q", avatar, updated.AvatarURL)
+	}
+	if user := db.users[created.ID]; user.Email != "avatar@example.com" || user.HashedPassword != hash {
+		t.Errorf("expected avatar-only update to keep email and password")
+	}
+
+	for _, bad := range []string{"not a url", "ftp://cdn.example.com/me.png", "https://", "/avatars/me.png", "javascript:alert(1)"} {
+		if rec := update(`{"avatar_url":"` + bad + `"}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected avatar_url %q to return %d, got %d", bad, http.StatusBadRequest, rec.Code)
+		}
+	}
+	if user := db.users[created.ID]; user.AvatarUrl != avatar {
+		t.Errorf("expected rejected updates to keep the avatar, got %q", user.AvatarUrl)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email":"avatar@example.com","password":"avatar-pass"}`))
+	rec = httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected login to return %d, got %d", http.StatusOK, rec.Code)
+	}
+	var login struct {
+		AvatarURL string `json:"avatar_url"`
+	}
+	json.NewDecoder(rec.Body).Decode(&login)
+	if login.AvatarURL != avatar {
+		t.Errorf("expected login avatar_url %q, got %q", avatar, login.AvatarURL)
+	}
+
+	if rec := update(`{"avatar_url":""}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected clearing the avatar to return %d, got %d", http.StatusOK, rec.Code)
+	}
+	if user := db.users[created.ID]; user.AvatarUrl != "" {
+		t.Errorf("expected avatar to be cleared, got %q", user.AvatarUrl)
+	}
+}
+
+func TestUserBio(t *testing.T) {
+	cfg, db := newTestConfig()
+	created, _ := db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{Email: "bio@example.com", HashedPassword: "hash"})
+	token := makeTestToken(t, created.ID)
+
+	profile := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/"+created.ID.String(), nil)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected profile to return %d, got %d", http.StatusOK, rec.Code)
+		}
+		var body struct {
+			Bio *string `json:"bio"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		if body.Bio == nil {
+			t.Fatalf("expected bio in the public profile")
+		}
+		return *body.Bio
+	}
+	update := func(bio string) int {
+		payload, _ := json.Marshal(map[string]string{"bio": bio})
+		req := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(string(payload)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if bio := profile(); bio != "" {
+		t.Fatalf("expected an empty bio by default, got %q", bio)
+	}
+
+	if code := update("Gopher, runner, occasional chirper."); code != http.StatusOK {
+		t.Fatalf("expected bio update to return %d, got %d", http.StatusOK, code)
+	}
+	if bio := profile(); bio != "Gopher, runner, occasional chirper." {
+		t.Errorf("expected the bio in the public profile, got %q", bio)
+	}
+
+	if code := update(strings.Repeat("é", maxBioLength)); code != http.StatusOK {
+		t.Errorf("expected a %d-character bio to return %d, got %d", maxBioLength, http.StatusOK, code)
+	}
+	if code := update(strings.Repeat("a", maxBioLength+1)); code != http.StatusBadRequest {
+		t.Errorf("expected an overlong bio to return %d, got %d", http.StatusBadRequest, code)
+	}
+	if bio := profile(); bio != strings.Repeat("é", maxBioLength) {
+		t.Errorf("expected a rejected bio to leave the old one, got %q", bio)
+	}
+}
+
+func TestValidationErrorFields(t *testing.T) {
+	cfg, _ := newTestConfig()
+	cfg.loginLimiter = nil
+
+	tests := []struct {
+		name       string
+		path       string
+		payload    string
+		wantFields map[string]string
+	}{
+		{
+			name:    "signup",
+			path:    "/api/users",
+			payload: `{"email":"not-an-email","username":"x!","password":"short"}`,
+			wantFields: map[string]string{
+				"email":    "email is not a valid address",
+				"username": "username must be 3 to 20 letters, digits or underscores",
+				"password": "password must be at least 8 characters",
+			},
+		},
+		{
+			name:    "signup missing fields",
+			path:    "/api/users",
+			payload: `{}`,
+			wantFields: map[string]string{
+				"email":    "email is required",
+				"password": "password is required",
+			},
+		},
+		{
+			name:    "login",
+			path:    "/api/login",
+			payload: `{}`,
+			wantFields: map[string]string{
+				"email":    "email or username is required",
+				"password": "password is required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.payload)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+			var body struct {
+				Error  string            `json:"error"`
+				Fields map[string]string `json:"fields"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if body.Error != "validation failed" {
+				t.Errorf("expected error %q, got %q", "validation failed", body.Error)
+			}
+			if !reflect.DeepEqual(body.Fields, tt.wantFields) {
+				t.Errorf("expected fields %v, got %v", tt.wantFields, body.Fields)
+			}
+		})
+	}
+}
+
+func TestDeleteUserRequiresToken(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "careful@example.com")
+
+	for name, header := range map[string]string{
+		"missing": "",
+		"invalid": "Bearer not-a-jwt",
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/users", nil)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+			}
+		})
+	}
+
+	if _, err := db.GetUserByID(context.Background(), user.ID); err != nil {
+		t.Errorf("expected user to survive unauthenticated deletes: %v", err)
+	}
+}
+
+func TestCreateUserDuplicateEmail(t *testing.T) {
+	cfg, db := newTestConfig()
+	db.CreateUser(context.Background(), "taken@example.com")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"email":"taken@example.com","password":"long-enough"}`))
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d", http.StatusConflict, rec.Code)
+	}
+	var body map[string]string
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body["error"] != "email already registered" {
+		t.Errorf("unexpected error body %v", body)
+	}
+}
+
+func TestEmailUniqueIgnoringCase(t *testing.T) {
+	cfg, db := newTestConfig()
+	cfg.loginLimiter = nil
+	serve := func(method, path, token, payload string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(payload))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		var body map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+
+	code, body := serve(http.MethodPost, "/api/users", "", `{"email":"Walt@Example.com","password":"long-enough"}`)
+	if code != http.StatusCreated || body["email"] != "walt@example.com" {
+		t.Fatalf("expected 201 with a lowercased email, got %d %v", code, body)
+	}
+	if code, _ := serve(http.MethodPost, "/api/users", "", `{"email":"WALT@example.com","password":"long-enough"}`); code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d", http.StatusConflict, code)
+	}
+
+	other, _ := db.CreateUser(context.Background(), "other@example.com")
+	token := makeTestToken(t, other.ID)
+	if code, _ := serve(http.MethodPut, "/api/users", token, `{"email":"walt@EXAMPLE.com"}`); code != http.StatusConflict {
+		t.Fatalf("expected update to status %d, got %d", http.StatusConflict, code)
+	}
+	code, body = serve(http.MethodPut, "/api/users", token, `{"email":"Other2@Example.com"}`)
+	if code != http.StatusOK || body["email"] != "other2@example.com" {
+		t.Fatalf("expected update to store a lowercased email, got %d %v", code, body)
+	}
+
+	if code, _ := serve(http.MethodPost, "/api/login", "", `{"email":"WALT@EXAMPLE.COM","password":"long-enough"}`); code != http.StatusOK {
+		t.Fatalf("expected login to ignore email case, got %d", code)
+	}
+}
+
+func TestUsernames(t *testing.T) {
+	cfg, _ := newTestConfig()
+	cfg.loginLimiter = nil
+
+	register := func(payload string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(payload)))
+		var body map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+
+	code, body := register(`{"email":"walter@example.com","username":"Heisenberg_1","password":"long-enough"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, code)
+	}
+	if body["username"] != "heisenberg_1" {
+		t.Fatalf("expected normalized username, got %v", body["username"])
+	}
+
+	code, body = register(`{"email":"jesse@example.com","password":"long-enough"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("expected registration without a username to succeed, got %d", code)
+	}
+	if v, ok := body["username"]; !ok || v != nil {
+		t.Fatalf("expected a null username, got %v (present=%v)", v, ok)
+	}
+
+	code, body = register(`{"email":"copycat@example.com","username":"HEISENBERG_1","password":"long-enough"}`)
+	if code != http.StatusConflict || body["error"] != "username already taken" {
+		t.Fatalf("expected 409 username already taken, got %d %v", code, body)
+	}
+	code, body = register(`{"email":"WALTER@example.com","username":"other","password":"long-enough"}`)
+	if code != http.StatusConflict || body["error"] != "email already registered" {
+		t.Fatalf("expected 409 email already registered, got %d %v", code, body)
+	}
+
+	for _, bad := range []string{"ab", "has space", "at@sign", "waytoolongusername_123", "ünï"} {
+		payload, _ := json.Marshal(map[string]string{"email": "bad@example.com", "username": bad, "password": "long-enough"})
+		if code, _ := register(string(payload)); code != http.StatusBadRequest {
+			t.Errorf("username %q: expected status %d, got %d", bad, http.StatusBadRequest, code)
+		}
+	}
+
+	login := func(payload string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(payload)))
+		var body map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+	for _, payload := range []string{
+		`{"username":"heisenberg_1","password":"long-enough"}`,
+		`{"email":"Heisenberg_1","password":"long-enough"}`,
+		`{"email":"walter@example.com","password":"long-enough"}`,
+	} {
+		code, body := login(payload)
+		if code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", payload, http.StatusOK, code)
+		}
+		if body["username"] != "heisenberg_1" || body["email"] != "walter@example.com" {
+			t.Fatalf("%s: unexpected login body %v", payload, body)
+		}
+	}
+	for _, payload := range []string{
+		`{"username":"heisenberg_1","password":"wrong-password"}`,
+		`{"username":"nobody","password":"long-enough"}`,
+	} {
+		if code, _ := login(payload); code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status %d, got %d", payload, http.StatusUnauthorized, code)
+		}
+	}
+	if code, _ := login(`{"password":"long-enough"}`); code != http.StatusBadRequest {
+		t.Errorf("expected login without email or username to return %d, got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	cfg, db := newTestConfig()
+	var logs strings.Builder
+	cfg.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	user, _ := db.CreateUser(context.Background(), "forgetful@example.com")
+	session, err := cfg.issueRefreshToken(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("issueRefreshToken failed: %v", err)
+	}
+
+	requestReset := func(email string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/password_reset", strings.NewReader(`{"email":"`+email+`"}`))
+		rec := httptest.NewRecorder()
+		cfg.handlePasswordReset(rec, req)
+		return rec.Code
+	}
+	confirm := func(token, password string) int {
+		payload, _ := json.Marshal(map[string]string{"token": token, "password": password})
+		req := httptest.NewRequest(http.MethodPost, "/api/password_reset/confirm", strings.NewReader(string(payload)))
+		rec := httptest.NewRecorder()
+		cfg.handlePasswordResetConfirm(rec, req)
+		return rec.Code
+	}
+
+	if code := requestReset("nobody@example.com"); code != http.StatusOK {
+		t.Fatalf("expected unknown email to return %d, got %d", http.StatusOK, code)
+	}
+	if len(db.resetTokens) != 0 {
+		t.Fatalf("expected no reset token for an unknown email")
+	}
+
+	if code := requestReset(user.Email); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+	token := loggedToken(t, logs.String(), "password reset token issued")
+	if _, ok := db.resetTokens[auth.HashToken(token)]; !ok || len(db.resetTokens) != 1 {
+		t.Fatalf("expected only the reset token's hash to be stored, got %v", db.resetTokens)
+	}
+
+	if code := confirm(token, "brand-new-password"); code != http.StatusNoContent {
+		t.Fatalf("expected reset to return %d, got %d", http.StatusNoContent, code)
+	}
+	match, err := auth.CheckPasswordHash("brand-new-password", db.users[user.ID].HashedPassword)
+	if err != nil || !match {
+		t.Fatalf("expected the new password to be stored")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+session)
+	rec := httptest.NewRecorder()
+	cfg.handleRefresh(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the reset to revoke existing sessions, got %d", rec.Code)
+	}
+
+	if code := confirm(token, "another-password"); code != http.StatusBadRequest {
+		t.Errorf("expected reused token to return %d, got %d", http.StatusBadRequest, code)
+	}
+
+	db.CreatePasswordResetToken(context.Background(), database.CreatePasswordResetTokenParams{
+		Token: auth.HashToken("expired"), UserID: user.ID, ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if code := confirm("expired", "another-password"); code != http.StatusBadRequest {
+		t.Errorf("expected expired token to return %d, got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "rotating@example.com")
+	original, err := cfg.issueRefreshToken(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("issueRefreshToken failed: %v", err)
+	}
+
+	refresh := func(token string) (int, map[string]string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handleRefresh(rec, req)
+		var body map[string]string
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+
+	code, body := refresh(original)
+	if code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+	rotated := body["refresh_token"]
+	if rotated == "" || rotated == original {
+		t.Fatalf("expected a new refresh token, got %q", rotated)
+	}
+	if body["token"] == "" {
+		t.Fatalf("expected a new access token")
+	}
+
+	if code, _ := refresh(original); code != http.StatusUnauthorized {
+		t.Errorf("expected replayed token to return %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code, _ := refresh(rotated); code != http.StatusOK {
+		t.Errorf("expected rotated token to work once, got %d", code)
+	}
+	if code, _ := refresh(rotated); code != http.StatusUnauthorized {
+		t.Errorf("expected rotated token to be rejected on reuse, got %d", code)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "sessions@example.com")
+	other, _ := db.CreateUser(context.Background(), "other@example.com")
+	laptop, _ := cfg.issueRefreshToken(context.Background(), user.ID)
+	phone, _ := cfg.issueRefreshToken(context.Background(), user.ID)
+	othersToken, _ := cfg.issueRefreshToken(context.Background(), other.ID)
+	token := makeTestToken(t, user.ID)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	raw := rec.Body.String()
+	var sessions []Session
+	if err := json.Unmarshal([]byte(raw), &sessions); err != nil {
+		t.Fatalf("failed to decode sessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != sessionID(auth.HashToken(phone)) || sessions[1].ID != sessionID(auth.HashToken(laptop)) {
+		t.Fatalf("expected the caller's two sessions, got %+v", sessions)
+	}
+	for _, tok := range []string{laptop, phone, othersToken} {
+		if strings.Contains(raw, tok) {
+			t.Fatalf("raw refresh token leaked in sessions response")
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+
+	for tok, want := range map[string]int{
+		laptop:      http.StatusUnauthorized,
+		phone:       http.StatusUnauthorized,
+		othersToken: http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		rec := httptest.NewRecorder()
+		cfg.handleRefresh(rec, req)
+		if rec.Code != want {
+			t.Errorf("expected refresh to return %d, got %d", want, rec.Code)
+		}
+	}
+}
+
+func TestEmailVerificationGatesChirps(t *testing.T) {
+	cfg, db := newTestConfig()
+	var logs strings.Builder
+	cfg.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"email":"new@example.com","password":"long-enough"}`))
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected signup to return %d, got %d", http.StatusCreated, rec.Code)
+	}
+	var created struct {
+		ID uuid.UUID `json:"id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+	token := makeTestToken(t, created.ID)
+
+	postChirp := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"hello"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := postChirp(); code != http.StatusForbidden {
+		t.Fatalf("expected unverified chirp to return %d, got %d", http.StatusForbidden, code)
+	}
+
+	verificationToken := loggedToken(t, logs.String(), "verification token issued")
+	if vt, ok := db.verifyTokens[auth.HashToken(verificationToken)]; !ok || vt.UserID != created.ID {
+		t.Fatalf("expected signup to store the verification token's hash, got %v", db.verifyTokens)
+	}
+
+	verify := func(tok string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/verify", strings.NewReader(`{"token":"`+tok+`"}`))
+		rec := httptest.NewRecorder()
+		cfg.handleVerify(rec, req)
+		return rec.Code
+	}
+	if code := verify(verificationToken); code != http.StatusNoContent {
+		t.Fatalf("expected verify to return %d, got %d", http.StatusNoContent, code)
+	}
+	if code := verify(verificationToken); code != http.StatusBadRequest {
+		t.Errorf("expected reused verification token to return %d, got %d", http.StatusBadRequest, code)
+	}
+
+	if code := postChirp(); code != http.StatusCreated {
+		t.Fatalf("expected verified chirp to return %d, got %d", http.StatusCreated, code)
+	}
+}
+
+func TestEmailVerificationOptional(t *testing.T) {
+	cfg, db := newTestConfig()
+	cfg.requireVerification = false
+	user, _ := db.CreateUser(context.Background(), "unverified@example.com")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"hello"}`))
+	req.Header.Set("Authorization", "Bearer "+makeTestToken(t, user.ID))
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected an unverified chirp to return %d without REQUIRE_EMAIL_VERIFICATION, got %d", http.StatusCreated, rec.Code)
+	}
+}
+
+func TestRefreshRejectsTokenRevokedMidRequest(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "racy@example.com")
+	token, _ := cfg.issueRefreshToken(context.Background(), user.ID)
+
+	// Simulate a concurrent refresh winning the rotation between lookup and revoke.
+	racing := &racingRevokeDB{fakeDB: db}
+	cfg.db = racing
+
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.handleRefresh(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+// racingRevokeDB revokes the token just before the handler's own revoke.
+type racingRevokeDB struct {
+	*fakeDB
+}
+
+func (r *racingRevokeDB) RevokeActiveRefreshToken(ctx context.Context, token string) (int64, error) {
+	r.fakeDB.RevokeActiveRefreshToken(ctx, token)
+	return r.fakeDB.RevokeActiveRefreshToken(ctx, token)
+}
+
+func TestRefreshTokensStoredHashed(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "hashed@example.com")
+	raw, err := cfg.issueRefreshToken(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("issueRefreshToken failed: %v", err)
+	}
+
+	for stored := range db.refreshTokens {
+		if stored == raw {
+			t.Fatalf("refresh token stored in plaintext")
+		}
+	}
+	if _, ok := db.refreshTokens[auth.HashToken(raw)]; !ok {
+		t.Fatalf("expected refresh token to be stored by its hash")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+raw)
+	rec := httptest.NewRecorder()
+	cfg.handleRefresh(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected raw token lookup to succeed, got %d", rec.Code)
+	}
+
+	var body map[string]string
+	json.NewDecoder(rec.Body).Decode(&body)
+	req = httptest.NewRequest(http.MethodPost, "/api/revoke", nil)
+	req.Header.Set("Authorization", "Bearer "+body["refresh_token"])
+	rec = httptest.NewRecorder()
+	cfg.handleRevoke(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected revoke to return %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rt := db.refreshTokens[auth.HashToken(body["refresh_token"])]; !rt.RevokedAt.Valid {
+		t.Fatalf("expected revoke to find the hashed token")
+	}
+}
+
+type closeRecorder struct{ closed bool }
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestShutdownRespectsCancelledContext(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	go http.Get(server.URL)
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	db := &closeRecorder{}
+
+	const timeout = time.Second
+	start := time.Now()
+	err := shutdown(ctx, server.Config, db, timeout)
+	if elapsed := time.Since(start); elapsed >= timeout {
+		t.Fatalf("shutdown took %v, expected it to return within %v", elapsed, timeout)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if !db.closed {
+		t.Fatalf("expected database to be closed")
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "everywhere@example.com")
+	phone, _ := cfg.issueRefreshToken(context.Background(), user.ID)
+	laptop, _ := cfg.issueRefreshToken(context.Background(), user.ID)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/logout_all", nil)
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d without a token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/logout_all", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken(t, user.ID))
+	rec = httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+
+	for _, token := range []string{phone, laptop} {
+		req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handleRefresh(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected refresh to fail after logout_all, got %d", rec.Code)
+		}
+	}
+}
+
+func TestRevokeAll(t *testing.T) {
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		t.Run(method, func(t *testing.T) {
+			cfg, db := newTestConfig()
+			user, _ := db.CreateUser(context.Background(), "revoker@example.com")
+			other, _ := db.CreateUser(context.Background(), "bystander@example.com")
+			phone, _ := cfg.issueRefreshToken(context.Background(), user.ID)
+			laptop, _ := cfg.issueRefreshToken(context.Background(), user.ID)
+			untouched, _ := cfg.issueRefreshToken(context.Background(), other.ID)
+
+			refresh := func(token string) int {
+				req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				cfg.handleRefresh(rec, req)
+				return rec.Code
+			}
+
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, httptest.NewRequest(method, "/api/revoke-all", nil))
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected status %d without a token, got %d", http.StatusUnauthorized, rec.Code)
+			}
+
+			req := httptest.NewRequest(method, "/api/revoke-all", nil)
+			req.Header.Set("Authorization", "Bearer "+makeTestToken(t, user.ID))
+			rec = httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+			}
+
+			for _, token := range []string{phone, laptop} {
+				if code := refresh(token); code != http.StatusUnauthorized {
+					t.Errorf("expected a revoked token to fail refresh, got %d", code)
+				}
+			}
+			if code := refresh(untouched); code != http.StatusOK {
+				t.Errorf("expected another user's token to keep working, got %d", code)
+			}
+		})
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	rec := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	rec.WriteHeader(http.StatusTeapot)
+	rec.Write([]byte("short and stout"))
+	if rec.status != http.StatusTeapot {
+		t.Fatalf("expected status %d, got %d", http.StatusTeapot, rec.status)
+	}
+
+	if rec.size != len("short and stout") {
+		t.Fatalf("expected size %d, got %d", len("short and stout"), rec.size)
+	}
+
+	rec = &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	rec.Write([]byte("ok"))
+	if rec.status != http.StatusOK {
+		t.Fatalf("expected implicit status %d, got %d", http.StatusOK, rec.status)
+	}
+}
+
+func TestEnvLogLevel(t *testing.T) {
+	for value, want := range map[string]slog.Level{
+		"":        slog.LevelInfo,
+		"debug":   slog.LevelDebug,
+		"WARN":    slog.LevelWarn,
+		"error":   slog.LevelError,
+		"verbose": slog.LevelInfo,
+	} {
+		t.Setenv("LOG_LEVEL", value)
+		if got := envLogLevel("LOG_LEVEL"); got != want {
+			t.Errorf("LOG_LEVEL=%q: expected %v, got %v", value, want, got)
+		}
+	}
+}
+
+func TestMiddlewareLog(t *testing.T) {
+	cfg, _ := newTestConfig()
+	var buf strings.Builder
+	cfg.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	handler := cfg.middlewareLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("gone"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/missing", nil))
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(buf.String()), &entry); err != nil {
+		t.Fatalf("expected a JSON log line, got %q", buf.String())
+	}
+	if entry["method"] != http.MethodGet || entry["path"] != "/api/missing" || entry["status"] != float64(http.StatusNotFound) || entry["size"] != float64(4) {
+		t.Fatalf("unexpected log entry: %v", entry)
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Fatalf("expected duration in log entry: %v", entry)
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var seen string
+	handler := middlewareRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFromContext(r.Context())
+		respondWithError(w, http.StatusBadRequest, "nope")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps", nil))
+	generated := rec.Header().Get("X-Request-ID")
+	if _, err := uuid.Parse(generated); err != nil {
+		t.Fatalf("expected a generated UUID request ID, got %q", generated)
+	}
+	if seen != generated {
+		t.Fatalf("expected context ID %q, got %q", generated, seen)
+	}
+	var body map[string]string
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body["request_id"] != generated {
+		t.Fatalf("expected error body to include request ID %q, got %q", generated, body["request_id"])
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+	req.Header.Set("X-Request-ID", "client-supplied")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "client-supplied" {
+		t.Fatalf("expected client request ID to be preserved, got %q", got)
+	}
+	if seen != "client-supplied" {
+		t.Fatalf("expected context ID %q, got %q", "client-supplied", seen)
+	}
+
+	for _, bad := range []string{strings.Repeat("a", maxRequestIDLength+1), "has space", "tab\there"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+		req.Header.Set("X-Request-ID", bad)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if _, err := uuid.Parse(rec.Header().Get("X-Request-ID")); err != nil {
+			t.Errorf("expected invalid request ID %q to be replaced, got %q", bad, rec.Header().Get("X-Request-ID"))
+		}
+	}
+}
+
+func TestSessionsExcludeRevokedAndExpired(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "live@example.com")
+	owner := uuid.NullUUID{UUID: user.ID, Valid: true}
+	live, _ := cfg.issueRefreshToken(context.Background(), user.ID)
+	db.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		Token: auth.HashToken("expired"), UserID: owner, ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	db.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		Token: auth.HashToken("revoked"), UserID: owner, ExpiresAt: time.Now().Add(time.Hour),
+	})
+	db.RevokeActiveRefreshToken(context.Background(), auth.HashToken("revoked"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken(t, user.ID))
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var sessions []Session
+	json.NewDecoder(rec.Body).Decode(&sessions)
+	if len(sessions) != 1 || sessions[0].ID != sessionID(auth.HashToken(live)) {
+		t.Fatalf("expected only the live session, got %+v", sessions)
+	}
+}
+
+func TestMiddlewareCORS(t *testing.T) {
+	cfg, _ := newTestConfig()
+	cfg.corsOrigins = parseOrigins("https://app.example.com, https://admin.example.com/")
+	var reached bool
+	handler := cfg.middlewareCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Run("preflight", func(t *testing.T) {
+		reached = false
+		req := httptest.NewRequest(http.MethodOptions, "/api/chirps", nil)
+		req.Header.Set("Origin", "https://admin.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent || reached {
+			t.Fatalf("expected preflight to short-circuit with %d, got %d", http.StatusNoContent, rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://admin.example.com" {
+			t.Fatalf("unexpected Allow-Origin %q", got)
+		}
+		if !strings.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "Authorization") {
+			t.Fatalf("expected Authorization in Allow-Headers, got %q", rec.Header().Get("Access-Control-Allow-Headers"))
+		}
+		if !strings.Contains(rec.Header().Get("Access-Control-Allow-Methods"), http.MethodDelete) {
+			t.Fatalf("expected DELETE in Allow-Methods, got %q", rec.Header().Get("Access-Control-Allow-Methods"))
+		}
+	})
+
+	t.Run("allowed origin", func(t *testing.T) {
+		reached = false
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if !reached || rec.Code != http.StatusOK {
+			t.Fatalf("expected request to reach handler, got %d", rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Fatalf("unexpected Allow-Origin %q", got)
+		}
+		if got := rec.Header().Values("Vary"); len(got) != 1 || got[0] != "Origin" {
+			t.Fatalf("expected a single Vary: Origin, got %q", got)
+		}
+	})
+
+	t.Run("no origins configured", func(t *testing.T) {
+		unset, _ := newTestConfig()
+		unset.corsOrigins = parseOrigins("")
+		req := httptest.NewRequest(http.MethodOptions, "/api/chirps", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		rec := httptest.NewRecorder()
+		unset.middlewareCORS(http.NotFoundHandler()).ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Fatalf("expected no Allow-Origin header, got %q", got)
+		}
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		reached = false
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+		req.Header.Set("Origin", "https://evil.example.com")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if !reached {
+			t.Fatalf("expected request to reach handler")
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Fatalf("expected no Allow-Origin header, got %q", got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Fatalf("expected Vary: Origin on a disallowed origin, got %q", got)
+		}
+	})
+
+	t.Run("no origin header", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps", nil))
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Fatalf("expected Vary: Origin without an Origin header, got %q", got)
+		}
+	})
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	cfg, _ := newTestConfig()
+	limiter := ratelimit.New(2, time.Minute)
+	handler := cfg.middlewareRateLimit(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	login := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := login("10.0.0.1:1234", ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected %d, got %d", i+1, http.StatusOK, rec.Code)
+		}
+	}
+	rec := login("10.0.0.1:5678", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected a Retry-After header")
+	}
+
+	// Without a trusted proxy, X-Forwarded-For can't be used to dodge the limit.
+	if rec := login("10.0.0.1:1234", "203.0.113.9"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected spoofed X-Forwarded-For to be ignored, got %d", rec.Code)
+	}
+
+	// Behind a trusted proxy the client is the entry the proxy appended;
+	// varying the entries to its left doesn't buy a fresh bucket.
+	cfg.trustProxy = true
+	for i, forged := range []string{"198.51.100.1", "198.51.100.2"} {
+		if rec := login("10.0.0.1:1234", forged+", 203.0.113.9"); rec.Code != http.StatusOK {
+			t.Fatalf("forwarded request %d: expected %d, got %d", i+1, http.StatusOK, rec.Code)
+		}
+	}
+	if rec := login("10.0.0.1:1234", "198.51.100.3, 203.0.113.9"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected forged X-Forwarded-For entries to share the proxy-reported bucket, got %d", rec.Code)
+	}
+}
+
+func TestNewLimiterDisabled(t *testing.T) {
+	cfg, _ := newTestConfig()
+	for _, limit := range []int{0, -1} {
+		limiter := newLimiter(limit, time.Minute)
+		if limiter != nil {
+			t.Fatalf("expected a limit of %d to disable limiting", limit)
+		}
+		handler := cfg.middlewareRateLimit(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/login", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("limit %d: expected %d, got %d", limit, http.StatusOK, rec.Code)
+		}
+	}
+	if newLimiter(1, time.Minute) == nil {
+		t.Fatalf("expected a positive limit to build a limiter")
+	}
+}
+
+func TestServerDrainsOnShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := newServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	if server.ReadTimeout == 0 || server.WriteTimeout == 0 || server.IdleTimeout == 0 {
+		t.Fatalf("expected server timeouts to be set")
+	}
+
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(ln) }()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+
+	db := &closeRecorder{}
+	done := make(chan error, 1)
+	go func() { done <- shutdown(context.Background(), server, db, 5*time.Second) }()
+
+	// Shutdown must wait for the in-flight request before closing the database.
+	time.Sleep(50 * time.Millisecond)
+	if db.closed {
+		t.Fatalf("database closed before in-flight request finished")
+	}
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatalf("shutdown returned %v", err)
+	}
+	if code := <-status; code != http.StatusOK {
+		t.Fatalf("expected in-flight request to complete with %d, got %d", http.StatusOK, code)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("expected Serve to return ErrServerClosed, got %v", err)
+	}
+	if !db.closed {
+		t.Fatalf("expected database to be closed after drain")
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "router@example.com")
+	chirp, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "routed", UserID: author.ID})
+	token := makeTestToken(t, author.ID)
+	mux := cfg.routes()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		auth       bool
+		wantStatus int
+	}{
+		{"get chirp", http.MethodGet, "/api/chirps/" + chirp.ID.String(), false, http.StatusOK},
+		{"chirp replies", http.MethodGet, "/api/chirps/" + chirp.ID.String() + "/replies", false, http.StatusOK},
+		{"like chirp", http.MethodPost, "/api/chirps/" + chirp.ID.String() + "/likes", true, http.StatusNoContent},
+		{"get user", http.MethodGet, "/api/users/" + author.ID.String(), false, http.StatusOK},
+		{"list sessions", http.MethodGet, "/api/sessions", true, http.StatusOK},
+		{"malformed chirp id", http.MethodGet, "/api/chirps/nope", false, http.StatusBadRequest},
+		{"trailing slash without id", http.MethodGet, "/api/chirps/", false, http.StatusNotFound},
+		{"unknown subresource", http.MethodGet, "/api/chirps/" + chirp.ID.String() + "/nope", false, http.StatusNotFound},
+		{"wrong method on chirp", http.MethodPatch, "/api/chirps/" + chirp.ID.String(), false, http.StatusMethodNotAllowed},
+		{"wrong method on login", http.MethodGet, "/api/login", false, http.StatusMethodNotAllowed},
+		{"wrong method on replies", http.MethodPost, "/api/chirps/" + chirp.ID.String() + "/replies", false, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.auth {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.wantStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAPINotFound(t *testing.T) {
+	cfg, _ := newTestConfig()
+	mux := cfg.routes()
+
+	for _, path := range []string{"/api/does-not-exist", "/api/nonexistent", "/nonexistent", "/admin/nonexistent"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusNotFound, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("%s: expected JSON content type, got %q", path, ct)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["error"] != "not found" {
+			t.Fatalf("%s: expected JSON not found error, got %v (%v)", path, body, err)
+		}
+	}
+
+	for path, want := range map[string]string{
+		"/api/sessions": "GET, DELETE",
+		"/api/users/me": "GET",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, path, nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusMethodNotAllowed, rec.Code)
+		}
+		if allow := rec.Header().Get("Allow"); allow != want {
+			t.Fatalf("%s: expected Allow %q, got %q", path, want, allow)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	if allow := rec.Header().Get("Allow"); rec.Code != http.StatusMethodNotAllowed || allow != "POST, PUT, PATCH, DELETE" {
+		t.Fatalf("expected 405 with Allow %q, got %d %q", "POST, PUT, PATCH, DELETE", rec.Code, allow)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/does-not-exist", nil))
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") == "application/json" {
+		t.Fatalf("expected the file server's own 404 under /app/, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+// fakePinger answers health-check pings with err.
+type fakePinger struct {
+	err error
+}
+
+func (p fakePinger) PingContext(ctx context.Context) error {
+	return p.err
+}
+
+// slowDB makes GetChirp take delay, giving up early like a real driver
+// when its context ends first.
+type slowDB struct {
+	*fakeDB
+	delay time.Duration
+}
+
+func (s slowDB) GetChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	select {
+	case <-time.After(s.delay):
+		return s.fakeDB.GetChirp(ctx, id)
+	case <-ctx.Done():
+		return database.Chirp{}, ctx.Err()
+	}
+}
+
+func TestDBQueryTimeout(t *testing.T) {
+	cfg, db := newTestConfig()
+	chirp, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "patience", UserID: uuid.New()})
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps/"+chirp.ID.String(), nil))
+		return rec
+	}
+
+	cfg.db = database.WithTimeout(slowDB{fakeDB: db, delay: time.Second}, 10*time.Millisecond)
+	rec := get()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	var body map[string]string
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body["error"] != "database timed out" {
+		t.Errorf("unexpected error body %v", body)
+	}
+
+	cfg.db = database.WithTimeout(slowDB{fakeDB: db, delay: time.Millisecond}, time.Second)
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Fatalf("expected a fast query to return %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestHealthzChecksDatabase(t *testing.T) {
+	tests := []struct {
+		name       string
+		pingErr    error
+		wantStatus int
+		wantBody   map[string]string
+	}{
+		{"database up", nil, http.StatusOK, map[string]string{"status": "OK"}},
+		{"database down", errors.New("connection refused"), http.StatusServiceUnavailable,
+			map[string]string{"status": "unavailable", "error": "database unreachable"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := newTestConfig()
+			cfg.sqlDB = fakePinger{err: tt.pingErr}
+
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/healthz", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			var body map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("expected a JSON body: %v", err)
+			}
+			for key, want := range tt.wantBody {
+				if body[key] != want {
+					t.Fatalf("expected %s %q, got %q", key, want, body[key])
+				}
+			}
+		})
+	}
+}
+
+func TestLivenessAndReadiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		pingErr    error
+		wantStatus int
+		wantState  string
+	}{
+		{"livez with database up", "/api/livez", nil, http.StatusOK, "alive"},
+		{"livez with database down", "/api/livez", errors.New("connection refused"), http.StatusOK, "alive"},
+		{"readyz with database up", "/api/readyz", nil, http.StatusOK, "ready"},
+		{"readyz with database down", "/api/readyz", errors.New("connection refused"), http.StatusServiceUnavailable, "unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := newTestConfig()
+			cfg.sqlDB = fakePinger{err: tt.pingErr}
+
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			var body map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["status"] != tt.wantState {
+				t.Fatalf("expected status %q, got %v (err %v)", tt.wantState, body, err)
+			}
+		})
+	}
+}
+
+func TestReadinessReportsUnreachableDatabase(t *testing.T) {
+	cfg, _ := newTestConfig()
+	sqlDB, err := sql.Open("postgres", "postgres://localhost/chirpy?sslmode=disable")
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	sqlDB.Close()
+	cfg.sqlDB = sqlDB
+
+	req := httptest.NewRequest(http.MethodGet, "/api/readyz", nil)
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["status"] != "unavailable" {
+		t.Fatalf("expected a JSON unavailable body, got %v (err %v)", body, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/healthz", nil)
+	rec = httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected healthz status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	body = nil
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["error"] != "database unreachable" {
+		t.Fatalf("expected healthz to describe the failure, got %v (err %v)", body, err)
+	}
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	cfg, db := newTestConfig()
+	now := time.Now()
+	cfg.loginLimiter = ratelimit.New(3, time.Minute)
+	cfg.loginLimiter.SetClock(func() time.Time { return now })
+	hashed, _ := auth.HashPassword("correct-password")
+	db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{
+		Email: "limited@example.com", HashedPassword: hashed,
+	})
+	mux := cfg.routes()
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body := `{"email":"limited@example.com","password":"` + password + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body))
+		req.RemoteAddr = "192.0.2.1:4000"
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := login("wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected %d, got %d", i+1, http.StatusUnauthorized, rec.Code)
+		}
+	}
+	// A successful login clears the failed attempts.
+	if rec := login("correct-password"); rec.Code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got %d", rec.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := login("wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d after reset: expected %d, got %d", i+1, http.StatusUnauthorized, rec.Code)
+		}
+	}
+	rec := login("correct-password")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d once the limit is exhausted, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "20" {
+		t.Fatalf("expected Retry-After of 20s, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	now = now.Add(time.Minute)
+	if rec := login("correct-password"); rec.Code != http.StatusOK {
+		t.Fatalf("expected login to recover after the window, got %d", rec.Code)
+	}
+}
+
+func TestMetricsProm(t *testing.T) {
+	cfg, _ := newTestConfig()
+	cfg.fileserverHits.Store(42)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/metrics.prom", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# HELP chirpy_fileserver_hits_total ",
+		"# TYPE chirpy_fileserver_hits_total counter\n",
+		"\nchirpy_fileserver_hits_total 42\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics output:\n%s", want, body)
+		}
+	}
+}
+
+// failingCountDB fails CountUsers to exercise the metrics fallback.
+type failingCountDB struct {
+	*fakeDB
+}
+
+func (f *failingCountDB) CountUsers(ctx context.Context) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestMetricsCounts(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "counted@example.com")
+	newVerifiedUser(t, db, "also-counted@example.com")
+	for i := 0; i < 3; i++ {
+		db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "chirp", UserID: author.ID})
+	}
+	cfg.fileserverHits.Store(7)
+
+	get := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	body := get()
+	for _, want := range []string{"visited 7 times", "Chirps: 3", "Users: 2"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics page:\n%s", want, body)
+		}
+	}
+
+	cfg.db = &failingCountDB{fakeDB: db}
+	body = get()
+	for _, want := range []string{"Chirps: 3", "Users: unavailable"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics page:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsContentNegotiation(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "negotiated@example.com")
+	db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "chirp", UserID: author.ID})
+	cfg.fileserverHits.Store(4)
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("application/json")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON content type, got %q", ct)
+	}
+	var body map[string]*int64
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+	if body["fileserver_hits"] == nil || *body["fileserver_hits"] != 4 || *body["chirps"] != 1 || *body["users"] != 1 {
+		t.Fatalf("unexpected metrics: hits=%v chirps=%v users=%v", body["fileserver_hits"], body["chirps"], body["users"])
+	}
+
+	cfg.db = &failingCountDB{fakeDB: db}
+	rec = get("application/json")
+	body = nil
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body["users"] != nil {
+		t.Fatalf("expected a failed count to be null, got %d", *body["users"])
+	}
+
+	rec = get("text/html,application/xhtml+xml")
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html" || !strings.Contains(rec.Body.String(), "visited 4 times") {
+		t.Fatalf("expected the HTML page for browsers, got %q:\n%s", ct, rec.Body.String())
+	}
+}
+
+func TestTokenExpiryInResponses(t *testing.T) {
+	cfg, db := newTestConfig()
+	hashed, _ := auth.HashPassword("correct-password")
+	db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{
+		Email: "expiry@example.com", HashedPassword: hashed,
+	})
+
+	type tokenResponse struct {
+		Token        string    `json:"token"`
+		RefreshToken string    `json:"refresh_token"`
+		ExpiresIn    int       `json:"expires_in"`
+		ExpiresAt    time.Time `json:"expires_at"`
+	}
+	checkExpiry := func(t *testing.T, got tokenResponse, want time.Duration) {
+		t.Helper()
+		if got.ExpiresIn != int(want.Seconds()) {
+			t.Fatalf("expected expires_in %d, got %d", int(want.Seconds()), got.ExpiresIn)
+		}
+		if d := time.Until(got.ExpiresAt); d <= want-time.Minute || d > want {
+			t.Fatalf("expected expires_at about %v from now, got %v", want, d)
+		}
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want time.Duration
+	}{
+		{"requested", `{"email":"expiry@example.com","password":"correct-password","expires_in_seconds":120}`, 2 * time.Minute},
+		{"default", `{"email":"expiry@example.com","password":"correct-password"}`, defaultAccessTokenTTL},
+		{"capped", `{"email":"expiry@example.com","password":"correct-password","expires_in_seconds":86400}`, defaultAccessTokenTTL},
+	}
+
+	var refreshToken string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			cfg.handleLogin(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+			}
+			var got tokenResponse
+			json.NewDecoder(rec.Body).Decode(&got)
+			checkExpiry(t, got, tt.want)
+			refreshToken = got.RefreshToken
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshToken)
+	rec := httptest.NewRecorder()
+	cfg.handleRefresh(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected refresh status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var got tokenResponse
+	json.NewDecoder(rec.Body).Decode(&got)
+	checkExpiry(t, got, defaultAccessTokenTTL)
+}
+
+func TestDeleteAllChirps(t *testing.T) {
+	tests := []struct {
+		name       string
+		platform   string
+		wantStatus int
+		wantLeft   int
+	}{
+		{"dev", "dev", http.StatusOK, 0},
+		{"prod", "prod", http.StatusForbidden, 2},
+		{"unset", "", http.StatusForbidden, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, db := newTestConfig()
+			cfg.platform = tt.platform
+			author := uuid.New()
+			db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "one", UserID: author})
+			db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "two", UserID: author})
+
+			req := httptest.NewRequest(http.MethodDelete, "/admin/chirps", nil)
+			req.Header.Set("Authorization", "Bearer "+testAdminToken)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if n, _ := db.CountChirps(context.Background()); n != int64(tt.wantLeft) {
+				t.Fatalf("expected %d chirps left, got %d", tt.wantLeft, n)
+			}
+		})
+	}
+}
+
+func TestChirpRateLimit(t *testing.T) {
+	cfg, db := newTestConfig()
+	cfg.chirpRateLimit = 3
+	cfg.chirpRateWindow = time.Minute
+	now := db.clock
+	cfg.now = func() time.Time { return now }
+	user := newVerifiedUser(t, db, "spammer@example.com")
+	token := makeTestToken(t, user.ID)
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"buy now"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < cfg.chirpRateLimit; i++ {
+		if rec := post(); rec.Code != http.StatusCreated {
+			t.Fatalf("chirp %d: expected status %d, got %d", i+1, http.StatusCreated, rec.Code)
+		}
+	}
+	rec := post()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected chirp over the limit to return %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("expected Retry-After %q, got %q", "60", got)
+	}
+	if n, _ := db.CountChirpsByAuthor(context.Background(), user.ID); n != int64(cfg.chirpRateLimit) {
+		t.Errorf("expected %d stored chirps, got %d", cfg.chirpRateLimit, n)
+	}
+
+	other := newVerifiedUser(t, db, "bystander@example.com")
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"hello"}`))
+	req.Header.Set("Authorization", "Bearer "+makeTestToken(t, other.ID))
+	rec = httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("expected another user to be unaffected, got %d", rec.Code)
+	}
+
+	now = now.Add(2 * time.Minute)
+	db.clock = now
+	if rec := post(); rec.Code != http.StatusCreated {
+		t.Fatalf("expected the limit to reset after the window, got %d", rec.Code)
+	}
+
+	// A bulk batch is charged in full: with one chirp already in the
+	// window, a batch of three would go over the limit of three.
+	bulk := func(n int) *httptest.ResponseRecorder {
+		bodies := make([]string, n)
+		for i := range bodies {
+			bodies[i] = fmt.Sprintf("bulk %d", i)
+		}
+		payload, _ := json.Marshal(map[string][]string{"chirps": bodies})
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps/bulk", strings.NewReader(string(payload)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := bulk(3); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected a batch over the limit to return %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if n, _ := db.CountChirpsByAuthor(context.Background(), user.ID); n != int64(cfg.chirpRateLimit)+1 {
+		t.Fatalf("expected the rejected batch to store nothing, got %d chirps", n)
+	}
+	if rec := bulk(2); rec.Code != http.StatusCreated {
+		t.Fatalf("expected a batch within the limit to return %d, got %d", http.StatusCreated, rec.Code)
+	}
+	if rec := post(); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the bulk chirps to use up the quota, got %d", rec.Code)
+	}
+}
+
+func TestCreateChirpRejectsBlankBody(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "blank@example.com")
+	token := makeTestToken(t, author.ID)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{"empty", "", http.StatusBadRequest, "chirp cannot be empty"},
+		{"whitespace only", " \t\n ", http.StatusBadRequest, "chirp cannot be empty"},
+		{"one char", "a", http.StatusCreated, ""},
+		{"too long", strings.Repeat("a", defaultMaxChirpLength+1), http.StatusBadRequest, "chirp is too long"},
+		{"padding not counted", "  " + strings.Repeat("a", defaultMaxChirpLength) + "\n", http.StatusCreated, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(map[string]string{"body": tt.body})
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(string(payload)))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			var body map[string]string
+			json.NewDecoder(rec.Body).Decode(&body)
+			if tt.wantError != "" && body["error"] != tt.wantError {
+				t.Fatalf("expected error %q, got %q", tt.wantError, body["error"])
+			}
+			if tt.wantStatus == http.StatusCreated && body["body"] != strings.TrimSpace(tt.body) {
+				t.Fatalf("expected stored body to be trimmed, got %q", body["body"])
+			}
+		})
+	}
+}
+
+func TestBulkCreateChirps(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "importer@example.com")
+	token := makeTestToken(t, author.ID)
+
+	post := func(chirps []string) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(map[string][]string{"chirps": chirps})
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps/bulk", strings.NewReader(string(payload)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post([]string{"first", "  second #bulk ", "what a kerfuffle"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	created := decodeChirps(t, rec)
+	wantBodies := []string{"first", "second #bulk", "what a ****"}
+	if len(created) != len(wantBodies) {
+		t.Fatalf("expected %d chirps, got %d", len(wantBodies), len(created))
+	}
+	for i, c := range created {
+		if c.Body != wantBodies[i] || c.UserID != author.ID {
+			t.Errorf("chirp %d: expected body %q by the caller, got %q by %s", i, wantBodies[i], c.Body, c.UserID)
+		}
+	}
+	if !db.tags[created[1].ID]["bulk"] {
+		t.Errorf("expected bulk chirps to be tagged")
+	}
+
+	before, _ := db.CountChirps(context.Background())
+	tests := []struct {
+		name      string
+		chirps    []string
+		wantError string
+	}{
+		{"over-length entry", []string{"fine", strings.Repeat("a", defaultMaxChirpLength+1)}, "chirps[1]: chirp is too long"},
+		{"blank entry", []string{"   ", "fine"}, "chirps[0]: chirp cannot be empty"},
+		{"empty batch", nil, "chirps is required"},
+		{"over the cap", make([]string, maxBulkChirps+1), fmt.Sprintf("at most %d chirps per batch", maxBulkChirps)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(tt.chirps)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+			var body map[string]string
+			json.NewDecoder(rec.Body).Decode(&body)
+			if body["error"] != tt.wantError {
+				t.Fatalf("expected error %q, got %q", tt.wantError, body["error"])
+			}
+		})
+	}
+	if after, _ := db.CountChirps(context.Background()); after != before {
+		t.Fatalf("expected rejected batches to create nothing, count went %d -> %d", before, after)
+	}
+
+	full := make([]string, maxBulkChirps)
+	for i := range full {
+		full[i] = "chirp"
+	}
+	if rec := post(full); rec.Code != http.StatusCreated {
+		t.Fatalf("expected a batch of exactly %d to succeed, got %d", maxBulkChirps, rec.Code)
+	}
+
+	cfg.createChirpsTx = func(context.Context, []database.CreateChirpParams) ([]database.Chirp, error) {
+		return nil, context.DeadlineExceeded
+	}
+	if rec := post([]string{"slow"}); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a timed-out batch to return %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestChirpLengthCountsRunes(t *testing.T) {
+	cfg, db := newTestConfig()
+
+	for _, r := range []string{"é", "🐦", "日"} {
+		if err := cfg.validateChirpBody(strings.Repeat(r, defaultMaxChirpLength)); err != nil {
+			t.Errorf("expected %d x %q to be allowed, got %v", defaultMaxChirpLength, r, err)
+		}
+		if err := cfg.validateChirpBody(strings.Repeat(r, defaultMaxChirpLength+1)); err == nil {
+			t.Errorf("expected %d x %q to be rejected", defaultMaxChirpLength+1, r)
+		}
+	}
+
+	author := newVerifiedUser(t, db, "emoji@example.com")
+	payload, _ := json.Marshal(map[string]string{"body": strings.Repeat("🐦", defaultMaxChirpLength)})
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(string(payload)))
+	req.Header.Set("Authorization", "Bearer "+makeTestToken(t, author.ID))
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+	}
+
+	cfg.maxChirpLength = 5
+	if err := cfg.validateChirpBody("héllo"); err != nil {
+		t.Errorf("expected 5 runes to fit a limit of 5, got %v", err)
+	}
+	if err := cfg.validateChirpBody("héllo!"); err == nil {
+		t.Errorf("expected 6 runes to exceed a limit of 5")
+	}
+}
+
+func TestValidatePasswordMixedClasses(t *testing.T) {
+	cfg, db := newTestConfig()
+	cfg.requireMixedPassword = true
+
+	tests := []struct {
+		password string
+		wantErr  bool
+	}{
+		{"alllowercase", true},
+		{"lowerUPPER", true},
+		{"lowerUPPER1", false},
+		{"lower123!", false},
+		{"Ab1!", true}, // mixed but too short
+	}
+	for _, tt := range tests {
+		if err := cfg.validatePassword(tt.password); (err != nil) != tt.wantErr {
+			t.Errorf("validatePassword(%q) = %v, wantErr %v", tt.password, err, tt.wantErr)
+		}
+	}
+
+	// Existing weak passwords must still be able to log in.
+	hashed, _ := auth.HashPassword("weak")
+	db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{
+		Email: "legacy@example.com", HashedPassword: hashed,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email":"legacy@example.com","password":"weak"}`))
+	rec := httptest.NewRecorder()
+	cfg.handleLogin(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected legacy weak password to log in, got %d", rec.Code)
+	}
+}
+
+// lookupCountingDB records which refresh token lookups a handler makes.
+type lookupCountingDB struct {
+	*fakeDB
+	validLookups, rowLookups int
+}
+
+func (l *lookupCountingDB) GetUserFromValidRefreshToken(ctx context.Context, token string) (database.GetUserFromValidRefreshTokenRow, error) {
+	l.validLookups++
+	return l.fakeDB.GetUserFromValidRefreshToken(ctx, token)
+}
+
+func (l *lookupCountingDB) GetRefreshToken(ctx context.Context, token string) (database.RefreshToken, error) {
+	l.rowLookups++
+	return l.fakeDB.GetRefreshToken(ctx, token)
+}
+
+func TestRefreshValidatesInOneQuery(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "one-query@example.com")
+	owner := uuid.NullUUID{UUID: user.ID, Valid: true}
+	valid, _ := cfg.issueRefreshToken(context.Background(), user.ID)
+	db.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		Token: auth.HashToken("expired"), UserID: owner, ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	db.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		Token: auth.HashToken("revoked"), UserID: owner, ExpiresAt: time.Now().Add(time.Hour),
+	})
+	db.RevokeActiveRefreshToken(context.Background(), auth.HashToken("revoked"))
+
+	tests := []struct {
+		name           string
+		token          string
+		wantStatus     int
+		wantRowLookups int
+	}{
+		{"valid", valid, http.StatusOK, 0},
+		{"expired", "expired", http.StatusUnauthorized, 1},
+		{"revoked", "revoked", http.StatusUnauthorized, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counting := &lookupCountingDB{fakeDB: db}
+			cfg.db = counting
+			req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			cfg.handleRefresh(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if counting.validLookups != 1 || counting.rowLookups != tt.wantRowLookups {
+				t.Fatalf("expected 1 validating lookup and %d row lookups, got %d and %d",
+					tt.wantRowLookups, counting.validLookups, counting.rowLookups)
+			}
+		})
+	}
+}
+
+func TestUsersMe(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "me@example.com")
+	gone, _ := db.CreateUser(context.Background(), "gone@example.com")
+	db.DeleteUser(context.Background(), gone.ID)
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+	}{
+		{"authenticated", "Bearer " + makeTestToken(t, user.ID), http.StatusOK},
+		{"missing token", "", http.StatusUnauthorized},
+		{"invalid token", "Bearer nope", http.StatusUnauthorized},
+		{"deleted user", "Bearer " + makeTestToken(t, gone.ID), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/users/me", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got map[string]interface{}
+			json.NewDecoder(rec.Body).Decode(&got)
+			if got["id"] != user.ID.String() || got["email"] != user.Email {
+				t.Fatalf("unexpected profile %v", got)
+			}
+			if _, ok := got["hashed_password"]; ok {
+				t.Fatalf("profile leaked hashed_password")
+			}
+		})
+	}
+}
+
+func TestAccessTokenTTL(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"15m":     15 * time.Minute,
+		"":        defaultAccessTokenTTL,
+		"soon":    defaultAccessTokenTTL,
+		"-5m":     defaultAccessTokenTTL,
+		"2h30m0s": 150 * time.Minute,
+	} {
+		t.Setenv("ACCESS_TOKEN_TTL", value)
+		if got := envDuration("ACCESS_TOKEN_TTL", defaultAccessTokenTTL); got != want {
+			t.Errorf("ACCESS_TOKEN_TTL=%q: expected %v, got %v", value, want, got)
+		}
+	}
+
+	cfg, db := newTestConfig()
+	cfg.accessTokenTTL = 15 * time.Minute
+	hashed, _ := auth.HashPassword("correct-password")
+	db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{
+		Email: "ttl@example.com", HashedPassword: hashed,
+	})
+
+	for body, want := range map[string]int{
+		`{"email":"ttl@example.com","password":"correct-password"}`:                           900,
+		`{"email":"ttl@example.com","password":"correct-password","expires_in_seconds":3600}`: 900,
+		`{"email":"ttl@example.com","password":"correct-password","expires_in_seconds":60}`:   60,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		cfg.handleLogin(rec, req)
+		var got struct {
+			ExpiresIn int `json:"expires_in"`
+		}
+		json.NewDecoder(rec.Body).Decode(&got)
+		if got.ExpiresIn != want {
+			t.Errorf("login %s: expected expires_in %d, got %d", body, want, got.ExpiresIn)
+		}
+	}
+}
+
+func TestMe(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "whoami@example.com")
+	gone, _ := db.CreateUser(context.Background(), "whowasi@example.com")
+	db.DeleteUser(context.Background(), gone.ID)
+	expired, err := auth.MakeJWT(user.ID, testJWTSecret, -time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"valid token", makeTestToken(t, user.ID), http.StatusOK},
+		{"expired token", expired, http.StatusUnauthorized},
+		{"deleted user", makeTestToken(t, gone.ID), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus == http.StatusOK && !strings.Contains(rec.Body.String(), user.ID.String()) {
+				t.Fatalf("expected profile for %s, got %s", user.ID, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestPolkaWebhookSignature(t *testing.T) {
+	const secret = "webhook-secret"
+
+	tests := []struct {
+		name       string
+		secret     string
+		apiKey     string
+		sign       string // body to sign; empty sends no signature
+		body       string
+		wantStatus int
+		wantRed    bool
+	}{
+		{"valid signature", secret, "", "{{body}}", "{{body}}", http.StatusNoContent, true},
+		{"tampered body", secret, "", "{{body}}", `{"event":"user.upgraded","data":{"user_id":"{{other}}"}}`, http.StatusUnauthorized, false},
+		{"missing signature", secret, "test-polka-key", "", "{{body}}", http.StatusUnauthorized, false},
+		{"no secret falls back to api key", "", "test-polka-key", "", "{{body}}", http.StatusNoContent, true},
+		{"no secret wrong api key", "", "wrong", "", "{{body}}", http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, db := newTestConfig()
+			cfg.polkaWebhookSecret = tt.secret
+			user, _ := db.CreateUser(context.Background(), "red@example.com")
+			other, _ := db.CreateUser(context.Background(), "other@example.com")
+			expand := func(s string) string {
+				s = strings.ReplaceAll(s, "{{body}}", `{"event":"user.upgraded","data":{"user_id":"`+user.ID.String()+`"}}`)
+				return strings.ReplaceAll(s, "{{other}}", other.ID.String())
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(expand(tt.body)))
+			if tt.sign != "" {
+				req.Header.Set("X-Signature", auth.SignPayload([]byte(expand(tt.sign)), secret))
+			}
+			if tt.apiKey != "" {
+				req.Header.Set("Authorization", "ApiKey "+tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			got, _ := db.GetUserByID(context.Background(), user.ID)
+			if got.IsChirpyRed != tt.wantRed {
+				t.Fatalf("expected is_chirpy_red %v, got %v", tt.wantRed, got.IsChirpyRed)
+			}
+			if o, _ := db.GetUserByID(context.Background(), other.ID); o.IsChirpyRed {
+				t.Fatalf("tampered payload upgraded another user")
+			}
+		})
+	}
+}
+
+// upgradeCountingDB counts upgrades and can fail them on demand.
+type upgradeCountingDB struct {
+	*fakeDB
+	upgrades int
+	fail     bool
+}
+
+func (u *upgradeCountingDB) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) (int64, error) {
+	if u.fail {
+		return 0, errors.New("database unavailable")
+	}
+	u.upgrades++
+	return u.fakeDB.UpgradeUserToChirpyRed(ctx, id)
+}
+
+func TestPolkaWebhookIdempotency(t *testing.T) {
+	cfg, db := newTestConfig()
+	counting := &upgradeCountingDB{fakeDB: db}
+	cfg.db = counting
+	user, _ := db.CreateUser(context.Background(), "retried@example.com")
+
+	deliver := func(body, idempotencyKey string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+		req.Header.Set("Authorization", "ApiKey test-polka-key")
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	withID := func(id string) string {
+		return `{"id":"` + id + `","event":"user.upgraded","data":{"user_id":"` + user.ID.String() + `"}}`
+	}
+
+	if code := deliver(withID("evt_1"), ""); code != http.StatusNoContent {
+		t.Fatalf("first delivery: expected %d, got %d", http.StatusNoContent, code)
+	}
+	if code := deliver(withID("evt_1"), ""); code != http.StatusNoContent {
+		t.Fatalf("replayed delivery: expected %d, got %d", http.StatusNoContent, code)
+	}
+	if counting.upgrades != 1 {
+		t.Fatalf("expected the replay to be skipped, got %d upgrades", counting.upgrades)
+	}
+
+	if code := deliver(withID("evt_2"), "key_1"); code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, code)
+	}
+	if code := deliver(withID("evt_3"), "key_1"); code != http.StatusNoContent || counting.upgrades != 2 {
+		t.Fatalf("expected Idempotency-Key to take precedence, got %d with %d upgrades", code, counting.upgrades)
+	}
+
+	// A failed delivery isn't remembered, so Polka's retry is processed.
+	counting.fail = true
+	if code := deliver(withID("evt_4"), ""); code != http.StatusInternalServerError {
+		t.Fatalf("expected failing delivery to return %d, got %d", http.StatusInternalServerError, code)
+	}
+	counting.fail = false
+	if code := deliver(withID("evt_4"), ""); code != http.StatusNoContent || counting.upgrades != 3 {
+		t.Fatalf("expected retry to be processed, got %d with %d upgrades", code, counting.upgrades)
+	}
+}
+
+func TestPolkaWebhookEventID(t *testing.T) {
+	cfg, db := newTestConfig()
+	counting := &upgradeCountingDB{fakeDB: db}
+	cfg.db = counting
+	user, _ := db.CreateUser(context.Background(), "event-id@example.com")
+
+	deliver := func(eventID string) int {
+		body := `{"event_id":"` + eventID + `","event":"user.upgraded","data":{"user_id":"` + user.ID.String() + `"}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+		req.Header.Set("Authorization", "ApiKey test-polka-key")
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := deliver("evt_same"); code != http.StatusNoContent {
+			t.Fatalf("delivery %d: expected %d, got %d", i+1, http.StatusNoContent, code)
+		}
+	}
+	if counting.upgrades != 1 {
+		t.Fatalf("expected a repeated event_id to upgrade once, got %d upgrades", counting.upgrades)
+	}
+
+	if code := deliver("evt_other"); code != http.StatusNoContent || counting.upgrades != 2 {
+		t.Fatalf("expected a distinct event_id to be processed, got %d with %d upgrades", code, counting.upgrades)
+	}
+}
+
+func TestGetChirpsDateRange(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := uuid.New()
+	var ids []uuid.UUID
+	var times []time.Time
+	for i := 0; i < 5; i++ {
+		c, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "dated chirp", UserID: author})
+		ids = append(ids, c.ID)
+		times = append(times, c.CreatedAt)
+	}
+	at := func(i int) string { return times[i].Format(time.RFC3339) }
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []uuid.UUID
+	}{
+		{"start inclusive end exclusive", "?start=" + at(1) + "&end=" + at(3), http.StatusOK, ids[1:3]},
+		{"start only", "?start=" + at(3), http.StatusOK, ids[3:]},
+		{"end only", "?end=" + at(1), http.StatusOK, ids[:1]},
+		{"with search", "?q=dated&start=" + at(4), http.StatusOK, ids[4:]},
+		{"empty window", "?start=2020-01-01T00:00:00Z&end=2020-02-01T00:00:00Z", http.StatusOK, nil},
+		{"malformed start", "?start=yesterday", http.StatusBadRequest, nil},
+		{"malformed end", "?end=2024-13-01", http.StatusBadRequest, nil},
+		{"start after end", "?start=" + at(3) + "&end=" + at(1), http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/chirps"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := chirpIDs(decodeChirps(t, rec)); !equalIDs(got, tt.wantIDs) {
+				t.Fatalf("expected %v, got %v", tt.wantIDs, got)
+			}
+		})
+	}
+}
+
+func TestPolkaWebhookEvents(t *testing.T) {
+	cfg, db := newTestConfig()
+	user, _ := db.CreateUser(context.Background(), "member@example.com")
+
+	deliver := func(event string, userID uuid.UUID) int {
+		body := `{"event":"` + event + `","data":{"user_id":"` + userID.String() + `"}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+		req.Header.Set("Authorization", "ApiKey test-polka-key")
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	isRed := func() bool {
+		u, _ := db.GetUserByID(context.Background(), user.ID)
+		return u.IsChirpyRed
+	}
+
+	if code := deliver("user.upgraded", user.ID); code != http.StatusNoContent || !isRed() {
+		t.Fatalf("expected upgrade to succeed, got %d (red=%v)", code, isRed())
+	}
+	if code := deliver("user.downgraded", user.ID); code != http.StatusNoContent || isRed() {
+		t.Fatalf("expected downgrade to succeed, got %d (red=%v)", code, isRed())
+	}
+	for _, event := range []string{"user.upgraded", "user.downgraded"} {
+		if code := deliver(event, uuid.New()); code != http.StatusNotFound {
+			t.Errorf("%s for a missing user: expected %d, got %d", event, http.StatusNotFound, code)
+		}
+	}
+	var logs strings.Builder
+	cfg.logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if code := deliver("user.renamed", user.ID); code != http.StatusNoContent {
+		t.Fatalf("expected unknown event to return %d, got %d", http.StatusNoContent, code)
+	}
+	if isRed() {
+		t.Fatalf("expected an unknown event to leave membership unchanged")
+	}
+	if !strings.Contains(logs.String(), `"event":"user.renamed"`) {
+		t.Fatalf("expected the ignored event to be logged, got %q", logs.String())
+	}
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "big@example.com")
+	huge := `{"body":"` + strings.Repeat("a", maxBodyBytes) + `"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(huge))
+	req.Header.Set("Authorization", "Bearer "+makeTestToken(t, author.ID))
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+	if len(db.chirps) != 0 {
+		t.Fatalf("expected no chirp to be stored, got %d", len(db.chirps))
+	}
+
+	login := `{"email":"big@example.com","password":"` + strings.Repeat("a", maxLoginBodyBytes) + `"}`
+	rec = httptest.NewRecorder()
+	cfg.handleLogin(rec, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(login)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected login status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+
+	webhook := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(huge))
+	webhook.Header.Set("Authorization", "ApiKey test-polka-key")
+	rec = httptest.NewRecorder()
+	cfg.handlePolkaWebhook(rec, webhook)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected webhook status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	cfg, db := newTestConfig()
+	cfg.loginLimiter = nil
+	author := newVerifiedUser(t, db, "decode@example.com")
+	token := makeTestToken(t, author.ID)
+
+	endpoints := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/api/users"},
+		{http.MethodPost, "/api/login"},
+		{http.MethodPost, "/api/chirps"},
+		{http.MethodPut, "/api/users"},
+	}
+	payloads := []struct {
+		name      string
+		body      string
+		wantError string
+	}{
+		{"empty", "", "request body is empty"},
+		{"whitespace only", "  \n", "request body is empty"},
+		{"malformed", `{"email":}`, "malformed JSON at byte 10"},
+		{"truncated", `{"email":"a@b.com"`, "malformed JSON: unexpected end of body"},
+		{"wrong type", `{"email":42}`, `field "email" has the wrong type`},
+		{"extra field", `{"nickname":"x"}`, `unknown field "nickname"`},
+	}
+
+	for _, ep := range endpoints {
+		for _, p := range payloads {
+			// Chirps have no email field, so it reads as an unknown field there.
+			if ep.path == "/api/chirps" && p.name == "wrong type" {
+				p.body, p.wantError = `{"body":42}`, `field "body" has the wrong type`
+			}
+			t.Run(ep.method+" "+ep.path+" "+p.name, func(t *testing.T) {
+				req := httptest.NewRequest(ep.method, ep.path, strings.NewReader(p.body))
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				cfg.routes().ServeHTTP(rec, req)
+				if rec.Code != http.StatusBadRequest {
+					t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+				}
+				var body map[string]string
+				json.NewDecoder(rec.Body).Decode(&body)
+				if body["error"] != p.wantError {
+					t.Fatalf("expected error %q, got %q", p.wantError, body["error"])
+				}
+			})
+		}
+	}
+}
+
+func TestUnknownJSONFieldsRejected(t *testing.T) {
+	cfg, db := newTestConfig()
+	hashed, _ := auth.HashPassword("correct-password")
+	db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{
+		Email: "strict@example.com", HashedPassword: hashed,
+	})
+
+	body := `{"email":"strict@example.com","pasword":"correct-password"}`
+	rec := httptest.NewRecorder()
+	cfg.handleLogin(rec, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	var resp map[string]string
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["error"] != `unknown field "pasword"` {
+		t.Fatalf("expected the stray field to be named, got %q", resp["error"])
+	}
+
+	webhook := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks",
+		strings.NewReader(`{"event":"user.upgraded","data":{"user_id":"`+uuid.NewString()+`"},"extra":1}`))
+	webhook.Header.Set("Authorization", "ApiKey test-polka-key")
+	rec = httptest.NewRecorder()
+	cfg.handlePolkaWebhook(rec, webhook)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected webhook status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestEndpointCounters(t *testing.T) {
+	cfg, db := newTestConfig()
+	mux := cfg.routes()
+	post := func(path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	counters := func() map[string]int64 {
+		req := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var body map[string]*int64
+		json.NewDecoder(rec.Body).Decode(&body)
+		got := map[string]int64{}
+		for _, name := range []string{"users_created", "chirps_created", "logins", "refreshes"} {
+			if body[name] == nil {
+				t.Fatalf("expected %s in metrics, got %v", name, body)
+			}
+			got[name] = *body[name]
+		}
+		return got
+	}
+
+	post("/api/users", `{"email":"counted@example.com","password":"Password123"}`, "")
+	rec := post("/api/login", `{"email":"counted@example.com","password":"Password123"}`, "")
+	var login map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&login)
+	refreshToken, _ := login["refresh_token"].(string)
+	post("/api/login", `{"email":"counted@example.com","password":"wrong"}`, "")
+	post("/api/refresh", "", refreshToken)
+	author := newVerifiedUser(t, db, "author@example.com")
+	post("/api/chirps", `{"body":"counted chirp"}`, makeTestToken(t, author.ID))
+
+	want := map[string]int64{"users_created": 1, "logins": 1, "refreshes": 1, "chirps_created": 1}
+	if got := counters(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected counters %v, got %v", want, got)
+	}
+
+	if rec := post("/admin/reset", "", testAdminToken); rec.Code != http.StatusOK {
+		t.Fatalf("reset failed with %d", rec.Code)
+	}
+	for name, n := range counters() {
+		if n != 0 {
+			t.Errorf("expected %s to reset to 0, got %d", name, n)
+		}
+	}
+}
+
+func TestPrometheusEndpoint(t *testing.T) {
+	cfg, _ := newTestConfig()
+	cfg.requestMetrics = metrics.NewRequests(prometheus.DefBuckets)
+	cfg.fileserverHits.Store(3)
+	handler := cfg.middlewareInstrument(cfg.routes())
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/livez", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/chirps/nope", nil))
+
+	scrape := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := scrape(""); rec.Code != http.StatusOK {
+		t.Fatalf("expected /metrics without METRICS_TOKEN configured to return %d, got %d", http.StatusOK, rec.Code)
+	}
+	cfg.metricsToken = "test-metrics-token"
+	for _, token := range []string{"", "wrong", testAdminToken} {
+		if rec := scrape(token); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected scrape with token %q to return %d, got %d", token, http.StatusUnauthorized, rec.Code)
+		}
+	}
+
+	rec := scrape(cfg.metricsToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"\nchirpy_fileserver_hits_total 3\n",
+		`chirpy_http_requests_total{route="GET /api/livez",status="200"} 2` + "\n",
+		`chirpy_http_requests_total{route="GET /api/chirps/{chirpID}",status="400"} 1` + "\n",
+		`chirpy_http_request_duration_seconds_count{route="GET /api/livez",status="200"} 2` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in /metrics output:\n%s", want, body)
+		}
+	}
+}
+
+func TestTagChirps(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "tagger@example.com")
+	token := makeTestToken(t, author.ID)
+
+	post := func(body string) Chirp {
+		payload, _ := json.Marshal(map[string]string{"body": body})
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(string(payload)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+		}
+		var c Chirp
+		json.NewDecoder(rec.Body).Decode(&c)
+		return c
+	}
+	older := post("Learning #Go, then more #go.")
+	post("nothing tagged here, not even C#")
+	newer := post("#golang is not #go")
+
+	if len(db.tags[older.ID]) != 1 || !db.tags[older.ID]["go"] {
+		t.Fatalf("expected older chirp tagged once with go, got %v", db.tags[older.ID])
+	}
+
+	list := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	for _, path := range []string{"/api/tags/go/chirps", "/api/tags/GO/chirps", "/api/tags/%23go/chirps"} {
+		rec := list(path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusOK, rec.Code)
+		}
+		got := chirpIDs(decodeChirps(t, rec))
+		if !reflect.DeepEqual(got, []uuid.UUID{newer.ID, older.ID}) {
+			t.Fatalf("%s: expected newest-first tagged chirps, got %v", path, got)
+		}
+	}
+
+	if got := chirpIDs(decodeChirps(t, list("/api/tags/go/chirps?limit=1&offset=1"))); !reflect.DeepEqual(got, []uuid.UUID{older.ID}) {
+		t.Fatalf("expected second page to hold the older chirp, got %v", got)
+	}
+	if got := decodeChirps(t, list("/api/tags/rust/chirps")); len(got) != 0 {
+		t.Fatalf("expected no chirps for an unused tag, got %d", len(got))
+	}
+	if rec := list("/api/tags/no-dashes/chirps"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid tag to return %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/chirps/"+older.ID.String(), strings.NewReader(`{"body":"Learning #rust now"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected edit to return %d, got %d", http.StatusOK, rec.Code)
+	}
+	if got := chirpIDs(decodeChirps(t, list("/api/tags/go/chirps"))); !reflect.DeepEqual(got, []uuid.UUID{newer.ID}) {
+		t.Fatalf("expected the edited chirp to drop its old tag, got %v", got)
+	}
+	if got := chirpIDs(decodeChirps(t, list("/api/tags/rust/chirps"))); !reflect.DeepEqual(got, []uuid.UUID{older.ID}) {
+		t.Fatalf("expected the edited chirp to gain its new tag, got %v", got)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "author@example.com")
+	withUsername := func(email, username string) database.CreateUserWithPasswordRow {
+		u, _ := db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{
+			Email:          email,
+			HashedPassword: "hash",
+			Username:       sql.NullString{String: username, Valid: true},
+		})
+		return u
+	}
+	alice := withUsername("someone@example.com", "Alice")
+	bob := withUsername("bob@example.com", "bob")
+	// Only usernames resolve: an email local part is not a handle.
+	db.CreateUser(context.Background(), "ghost@corp.example")
+
+	post := func(body string) Chirp {
+		payload, _ := json.Marshal(map[string]string{"body": body})
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(string(payload)))
+		req.Header.Set("Authorization", "Bearer "+makeTestToken(t, author.ID))
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+		}
+		var c Chirp
+		json.NewDecoder(rec.Body).Decode(&c)
+		return c
+	}
+	both := post("hey @alice and @Bob, have you met @ghost or @twin?")
+	post("no mentions, just mail bob@example.com")
+	onlyAlice := post("@ALICE again.")
+
+	if got := db.mentions[both.ID]; len(got) != 2 || !got[alice.ID] || !got[bob.ID] {
+		t.Fatalf("expected only alice and bob mentioned, got %v", got)
+	}
+
+	mentions := func(userID uuid.UUID, query string) []uuid.UUID {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/me/mentions"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+makeTestToken(t, userID))
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		return chirpIDs(decodeChirps(t, rec))
+	}
+	if got := mentions(alice.ID, ""); !reflect.DeepEqual(got, []uuid.UUID{onlyAlice.ID, both.ID}) {
+		t.Fatalf("expected alice's mentions newest first, got %v", got)
+	}
+	if got := mentions(alice.ID, "?limit=1&offset=1"); !reflect.DeepEqual(got, []uuid.UUID{both.ID}) {
+		t.Fatalf("expected second page to hold the older mention, got %v", got)
+	}
+	if got := mentions(bob.ID, ""); !reflect.DeepEqual(got, []uuid.UUID{both.ID}) {
+		t.Fatalf("expected bob's single mention, got %v", got)
+	}
+	if got := mentions(author.ID, ""); len(got) != 0 {
+		t.Fatalf("expected no mentions for the author, got %v", got)
+	}
+
+	edit := httptest.NewRequest(http.MethodPut, "/api/chirps/"+both.ID.String(), strings.NewReader(`{"body":"actually just @bob"}`))
+	edit.Header.Set("Authorization", "Bearer "+makeTestToken(t, author.ID))
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, edit)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected edit to return %d, got %d", http.StatusOK, rec.Code)
+	}
+	if got := mentions(alice.ID, ""); !reflect.DeepEqual(got, []uuid.UUID{onlyAlice.ID}) {
+		t.Fatalf("expected the edit to drop alice's mention, got %v", got)
+	}
+	if got := mentions(bob.ID, ""); !reflect.DeepEqual(got, []uuid.UUID{both.ID}) {
+		t.Fatalf("expected bob to stay mentioned after the edit, got %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users/me/mentions", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d without a token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestListChirpsIncludeAuthor(t *testing.T) {
+	cfg, db := newTestConfig()
+	alice, _ := db.CreateUser(context.Background(), "alice@example.com")
+	bob, _ := db.CreateUser(context.Background(), "bob@example.com")
+	db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "from alice", UserID: alice.ID})
+	db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "from bob", UserID: bob.ID})
+
+	list := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps"+query, nil))
+		return rec
+	}
+
+	rec := list("")
+	if strings.Contains(rec.Body.String(), "author_email") {
+		t.Fatalf("expected no author_email by default, got %s", rec.Body.String())
+	}
+
+	got := decodeChirps(t, list("?include=author"))
+	if len(got) != 2 || got[0].AuthorEmail != alice.Email || got[1].AuthorEmail != bob.Email {
+		t.Fatalf("expected author emails alice then bob, got %+v", got)
+	}
+
+	got = decodeChirps(t, list("?include=author&author_id="+bob.ID.String()))
+	if len(got) != 1 || got[0].AuthorEmail != bob.Email {
+		t.Fatalf("expected only bob's chirp with his email, got %+v", got)
+	}
+
+	for _, query := range []string{"?include=likes", "?include=author&q=alice", "?include=author&after=" + uuid.NewString()} {
+		if rec := list(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestListChirpsCount(t *testing.T) {
+	cfg, db := newTestConfig()
+	alice, bob := uuid.New(), uuid.New()
+	for i := 0; i < 3; i++ {
+		db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "alice", UserID: alice})
+	}
+	gone, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "bob", UserID: bob})
+	db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "bob", UserID: bob})
+	db.SoftDeleteChirp(context.Background(), gone.ID)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantCount  int64
+	}{
+		{"count=true", http.StatusOK, 4},
+		{"count=true&author_id=" + alice.String(), http.StatusOK, 3},
+		{"count=true&author_id=" + bob.String(), http.StatusOK, 1},
+		{"count=true&author_id=" + uuid.NewString(), http.StatusOK, 0},
+		{"count=maybe", http.StatusBadRequest, 0},
+		{"count=true&q=alice", http.StatusBadRequest, 0},
+		{"count=true&start=2026-01-01T00:00:00Z", http.StatusBadRequest, 0},
+		{"count=true&end=2026-01-01T00:00:00Z", http.StatusBadRequest, 0},
+		{"count=true&include=author", http.StatusBadRequest, 0},
+		{"count=true&after=" + gone.ID.String(), http.StatusBadRequest, 0},
+		{"count=true&before=", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body map[string]int64
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+			if len(body) != 1 || body["count"] != tt.wantCount {
+				t.Fatalf("expected {\"count\": %d}, got %v", tt.wantCount, body)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps?count=false", nil))
+	if got := decodeChirps(t, rec); len(got) != 4 {
+		t.Fatalf("expected count=false to list 4 chirps, got %d", len(got))
+	}
+}
+
+func TestListChirpsCursor(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := uuid.New()
+	var all []uuid.UUID
+	for i := 0; i < 5; i++ {
+		c, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "cursor chirp", UserID: author})
+		all = append(all, c.ID)
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cfg.handleListChirps(rec, httptest.NewRequest(http.MethodGet, "/api/chirps?"+query, nil))
+		return rec
+	}
+	type page struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor *string `json:"next_cursor"`
+	}
+
+	seen := chirpIDs(decodeChirps(t, get("limit=1")))
+	cursor := seen[0].String()
+	for pages := 0; ; pages++ {
+		if pages > len(all) {
+			t.Fatalf("cursor pagination did not terminate")
+		}
+		rec := get("limit=2&after=" + url.QueryEscape(cursor))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var p page
+		if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+			t.Fatalf("failed to decode page: %v", err)
+		}
+		seen = append(seen, chirpIDs(p.Chirps)...)
+		if p.NextCursor == nil {
+			break
+		}
+		cursor = *p.NextCursor
+		// Deleting the chirp a cursor points at must not break the walk.
+		if err := db.SoftDeleteChirp(context.Background(), seen[len(seen)-1]); err != nil {
+			t.Fatalf("SoftDeleteChirp failed: %v", err)
+		}
+	}
+	if !equalIDs(seen, all) {
+		t.Fatalf("expected pages to cover every chirp once in order\nwant %v\ngot  %v", all, seen)
+	}
+
+	for _, query := range []string{"after=nope", "after=" + uuid.NewString(), "after=" + all[0].String() + "&offset=1"} {
+		if rec := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestListChirpsBeforeCursor(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := uuid.New()
+	var all []uuid.UUID
+	for i := 0; i < 5; i++ {
+		c, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "older chirp", UserID: author})
+		all = append([]uuid.UUID{c.ID}, all...)
+	}
+	db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "someone else", UserID: uuid.New()})
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cfg.handleListChirps(rec, httptest.NewRequest(http.MethodGet, "/api/chirps?"+query, nil))
+		return rec
+	}
+	type page struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor *string `json:"next_cursor"`
+	}
+
+	var seen []uuid.UUID
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(all) {
+			t.Fatalf("cursor pagination did not terminate")
+		}
+		rec := get("limit=2&author_id=" + author.String() + "&before=" + url.QueryEscape(cursor))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var p page
+		if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+			t.Fatalf("failed to decode page: %v", err)
+		}
+		seen = append(seen, chirpIDs(p.Chirps)...)
+		if pages == 0 {
+			// A chirp posted mid-walk is newer than every cursor, so it
+			// must not shift later pages.
+			db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "late chirp", UserID: author})
+		}
+		if p.NextCursor == nil {
+			break
+		}
+		cursor = *p.NextCursor
+	}
+	if !equalIDs(seen, all) {
+		t.Fatalf("expected pages to cover every chirp once, newest first\nwant %v\ngot  %v", all, seen)
+	}
+
+	for _, query := range []string{
+		"before=nope",
+		"before=" + base64.RawURLEncoding.EncodeToString([]byte("yesterday_"+uuid.NewString())),
+		"before=" + base64.RawURLEncoding.EncodeToString([]byte(time.Now().Format(time.RFC3339Nano))),
+		"before=&offset=1",
+		"before=&sort=asc",
+		"before=&after=" + all[0].String(),
+	} {
+		if rec := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestLoginNoRefresh(t *testing.T) {
+	cfg, db := newTestConfig()
+	hashed, _ := auth.HashPassword("correct-password")
+	db.CreateUserWithPassword(context.Background(), database.CreateUserWithPasswordParams{
+		Email: "script@example.com", HashedPassword: hashed,
+	})
+
+	login := func(body string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		cfg.handleLogin(rec, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+
+	resp := login(`{"email":"script@example.com","password":"correct-password"}`)
+	if resp["refresh_token"] == nil || resp["token"] == nil {
+		t.Fatalf("expected both tokens by default, got %v", resp)
+	}
+	if len(db.refreshTokens) != 1 {
+		t.Fatalf("expected one stored refresh token, got %d", len(db.refreshTokens))
+	}
+
+	resp = login(`{"email":"script@example.com","password":"correct-password","no_refresh":true}`)
+	if _, ok := resp["refresh_token"]; ok {
+		t.Fatalf("expected no refresh_token with no_refresh, got %v", resp)
+	}
+	if resp["token"] == nil {
+		t.Fatalf("expected an access token with no_refresh, got %v", resp)
+	}
+	if len(db.refreshTokens) != 1 {
+		t.Fatalf("expected no_refresh to skip storing a token, got %d stored", len(db.refreshTokens))
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		header     string
+		wantStatus int
+	}{
+		{"missing token", testAdminToken, "", http.StatusUnauthorized},
+		{"wrong token", testAdminToken, "Bearer not-the-token", http.StatusUnauthorized},
+		{"not a bearer token", testAdminToken, "ApiKey " + testAdminToken, http.StatusUnauthorized},
+		{"no token configured", "", "Bearer ", http.StatusUnauthorized},
+		{"correct token", testAdminToken, "Bearer " + testAdminToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, route := range []struct{ method, path string }{
+				{http.MethodGet, "/admin/metrics"},
+				{http.MethodPost, "/admin/reset"},
+			} {
+				cfg, _ := newTestConfig()
+				cfg.adminToken = tt.configured
+				req := httptest.NewRequest(route.method, route.path, nil)
+				if tt.header != "" {
+					req.Header.Set("Authorization", tt.header)
+				}
+				rec := httptest.NewRecorder()
+				cfg.routes().ServeHTTP(rec, req)
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("%s %s: expected status %d, got %d", route.method, route.path, tt.wantStatus, rec.Code)
+				}
+			}
+		})
+	}
+
+	// The dev-platform guard on reset still applies to an admin.
+	cfg, _ := newTestConfig()
+	cfg.platform = "prod"
+	req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected reset outside dev to return %d, got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestMiddlewareAuth(t *testing.T) {
+	cfg, _ := newTestConfig()
+	userID := uuid.New()
+	var seen uuid.UUID
+	calls := 0
+	handler := cfg.middlewareAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		seen = userIDFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	expired, err := auth.MakeJWT(userID, testJWTSecret, -time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+	forged, err := auth.MakeJWT(userID, "some-other-secret", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	for _, header := range []string{"", "Bearer", "Bearer nope", "Bearer " + expired, "Bearer " + forged, "ApiKey " + makeTestToken(t, userID)} {
+		req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected status %d, got %d", header, http.StatusUnauthorized, rec.Code)
+		}
+	}
+	if calls != 0 {
+		t.Fatalf("expected rejected requests not to reach the handler, got %d calls", calls)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken(t, userID))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || seen != userID {
+		t.Fatalf("expected handler to see user %v, got %v (status %d)", userID, seen, rec.Code)
+	}
+}
+
+func TestSoftDeleteAndRestoreChirp(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "regretful@example.com")
+	token := makeTestToken(t, author.ID)
+	chirp, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "oops", UserID: author.ID})
+	mux := cfg.routes()
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	chirpPath := "/api/chirps/" + chirp.ID.String()
+
+	if rec := do(http.MethodDelete, chirpPath, token); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected delete to return %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := do(http.MethodGet, chirpPath, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected deleted chirp to be hidden, got %d", rec.Code)
+	}
+	if chirps := decodeChirps(t, do(http.MethodGet, "/api/chirps", "")); len(chirps) != 0 {
+		t.Fatalf("expected deleted chirp to be left out of listings, got %d chirps", len(chirps))
+	}
+
+	stranger := makeTestToken(t, newVerifiedUser(t, db, "stranger@example.com").ID)
+	if rec := do(http.MethodPost, chirpPath+"/restore", stranger); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected restore by another user to return %d, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	rec := do(http.MethodPost, chirpPath+"/restore", token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected restore to return %d, got %d", http.StatusOK, rec.Code)
+	}
+	var restored Chirp
+	json.NewDecoder(rec.Body).Decode(&restored)
+	if restored.ID != chirp.ID || restored.Body != "oops" {
+		t.Fatalf("unexpected restored chirp: %+v", restored)
+	}
+	if rec := do(http.MethodGet, chirpPath, ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected restored chirp to be visible, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, chirpPath+"/restore", token); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected restoring a live chirp to return %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestRestoreChirpAfterWindow(t *testing.T) {
+	cfg, db := newTestConfig()
+	author := newVerifiedUser(t, db, "too-late@example.com")
+	token := makeTestToken(t, author.ID)
+	expired, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "long gone", UserID: author.ID})
+	recent, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "just gone", UserID: author.ID})
+	reply, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{
+		Body:     "someone else's reply",
+		UserID:   uuid.New(),
+		ParentID: uuid.NullUUID{UUID: expired.ID, Valid: true},
+	})
+	db.SoftDeleteChirp(context.Background(), expired.ID)
+	db.SoftDeleteChirp(context.Background(), recent.ID)
+	for i := range db.chirps {
+		if db.chirps[i].ID == expired.ID {
+			db.chirps[i].DeletedAt.Time = time.Now().Add(-cfg.chirpRestoreWindow - time.Minute)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps/"+expired.ID.String()+"/restore", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected restore after the window to return %d, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	purged, err := cfg.purgeDeletedChirps(context.Background())
+	if err != nil || purged != 1 {
+		t.Fatalf("expected one chirp purged, got %d (%v)", purged, err)
+	}
+	if _, err := db.GetDeletedChirp(context.Background(), expired.ID); err != sql.ErrNoRows {
+		t.Fatalf("expected expired chirp to be hard-deleted, got %v", err)
+	}
+	if _, err := db.GetDeletedChirp(context.Background(), recent.ID); err != nil {
+		t.Fatalf("expected chirp inside the window to survive the purge, got %v", err)
+	}
+	orphan, err := db.GetChirp(context.Background(), reply.ID)
+	if err != nil {
+		t.Fatalf("expected the reply to survive its parent's purge, got %v", err)
+	}
+	if orphan.ParentID.Valid {
+		t.Fatalf("expected the reply's parent_id to be cleared, got %v", orphan.ParentID.UUID)
+	}
+}
+
+func TestDeleteMyChirps(t *testing.T) {
+	cfg, db := newTestConfig()
+	me := newVerifiedUser(t, db, "purging@example.com")
+	other := newVerifiedUser(t, db, "bystander@example.com")
+	for i := 0; i < 3; i++ {
+		db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "mine", UserID: me.ID})
+	}
+	kept, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "theirs", UserID: other.ID})
+
+	del := func(path string) map[string]int64 {
+		req := httptest.NewRequest(http.MethodDelete, path, nil)
+		req.Header.Set("Authorization", "Bearer "+makeTestToken(t, me.ID))
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var body map[string]int64
+		json.NewDecoder(rec.Body).Decode(&body)
+		return body
+	}
+
+	// An author_id pointing at someone else must not widen the delete.
+	if body := del("/api/chirps/mine?author_id=" + other.ID.String()); body["deleted"] != 3 {
+		t.Fatalf("expected 3 chirps deleted, got %v", body)
+	}
+	if n, _ := db.CountChirps(context.Background()); n != 1 {
+		t.Fatalf("expected only the other user's chirp to remain, got %d chirps", n)
+	}
+	if _, err := db.GetChirp(context.Background(), kept.ID); err != nil {
+		t.Fatalf("expected other user's chirp to be untouched, got %v", err)
+	}
+	if body := del("/api/chirps/mine"); body["deleted"] != 0 {
+		t.Fatalf("expected nothing left to delete, got %v", body)
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/chirps/mine", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d without a token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestDeleteChirpStatuses(t *testing.T) {
+	cfg, db := newTestConfig()
+	owner := newVerifiedUser(t, db, "owner@example.com")
+	other := newVerifiedUser(t, db, "other@example.com")
+
+	tests := []struct {
+		name       string
+		chirpID    func(existing uuid.UUID) string
+		token      string
+		wantStatus int
+	}{
+		{"owner deletes", func(id uuid.UUID) string { return id.String() }, makeTestToken(t, owner.ID), http.StatusNoContent},
+		{"non-owner", func(id uuid.UUID) string { return id.String() }, makeTestToken(t, other.ID), http.StatusForbidden},
+		{"missing token", func(id uuid.UUID) string { return id.String() }, "", http.StatusUnauthorized},
+		{"malformed id without token", func(uuid.UUID) string { return "nope" }, "", http.StatusUnauthorized},
+		{"malformed id", func(uuid.UUID) string { return "nope" }, makeTestToken(t, owner.ID), http.StatusBadRequest},
+		{"nonexistent chirp", func(uuid.UUID) string { return uuid.NewString() }, makeTestToken(t, owner.ID), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chirp, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "deletable", UserID: owner.ID})
+			req := httptest.NewRequest(http.MethodDelete, "/api/chirps/"+tt.chirpID(chirp.ID), nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			_, err := db.GetChirp(context.Background(), chirp.ID)
+			if deleted := err == sql.ErrNoRows; deleted != (tt.wantStatus == http.StatusNoContent) {
+				t.Fatalf("unexpected chirp state after %d: deleted=%v", rec.Code, deleted)
+			}
+		})
+	}
+}
+
+func TestGetChirpETag(t *testing.T) {
+	cfg, db := newTestConfig()
+	author, _ := db.CreateUser(context.Background(), "etag@example.com")
+	chirp, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "cache me", UserID: author.ID})
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps/"+chirp.ID.String(), nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" || etag[0] != '"' {
+		t.Fatalf("expected a quoted ETag, got %q", etag)
+	}
+	if again := get("").Header().Get("ETag"); again != etag {
+		t.Fatalf("expected a stable ETag, got %q then %q", etag, again)
+	}
+
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		rec := get(header)
+		if rec.Code != http.StatusNotModified {
+			t.Fatalf("If-None-Match %s: expected status %d, got %d", header, http.StatusNotModified, rec.Code)
+		}
+		if rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+			t.Fatalf("If-None-Match %s: expected an empty 304 carrying the ETag", header)
+		}
+	}
+
+	db.UpdateChirp(context.Background(), database.UpdateChirpParams{ID: chirp.ID, Body: "edited"})
+	if rec := get(etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatalf("expected an edit to change the ETag, got %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	etag = get("").Header().Get("ETag")
+	db.CreateLike(context.Background(), database.CreateLikeParams{ChirpID: chirp.ID, UserID: author.ID})
+	if rec := get(etag); rec.Code != http.StatusOK {
+		t.Fatalf("expected a new like to change the ETag, got %d", rec.Code)
+	}
+}
+
+func TestListChirpsETag(t *testing.T) {
+	cfg, db := newTestConfig()
+	author, _ := db.CreateUser(context.Background(), "listetag@example.com")
+	first, _ := db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "first", UserID: author.ID})
+	db.CreateChirp(context.Background(), database.CreateChirpParams{Body: "second", UserID: author.ID})
+
+	get := func(query, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps"+query, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		cfg.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", rec.Code, etag)
+	}
+	if rec := get("", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("expected an empty %d for a matching If-None-Match, got %d", http.StatusNotModified, rec.Code)
+	}
+	if other := get("?sort=desc", "").Header().Get("ETag"); other == etag {
+		t.Fatalf("expected a differently ordered list to get its own ETag")
+	}
+
+	ctx := context.Background()
+	changes := []struct {
+		name  string
+		apply func()
+	}{
+		{"like", func() { db.CreateLike(ctx, database.CreateLikeParams{ChirpID: first.ID, UserID: author.ID}) }},
+		{"edit", func() { db.UpdateChirp(ctx, database.UpdateChirpParams{ID: first.ID, Body: "edited"}) }},
+		{"delete", func() { db.SoftDeleteChirp(ctx, first.ID) }},
+		{"new", func() { db.CreateChirp(ctx, database.CreateChirpParams{Body: "third", UserID: author.ID}) }},
+	}
+	for _, change := range changes {
+		etag = get("", "").Header().Get("ETag")
+		change.apply()
+		if rec := get("", etag); rec.Code != http.StatusOK {
+			t.Fatalf("expected a %s to change the list ETag, got %d", change.name, rec.Code)
+		}
+	}
+}
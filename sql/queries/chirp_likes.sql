@@ -0,0 +1,13 @@
+-- name: CreateLike :exec
+INSERT INTO chirp_likes (chirp_id, user_id)
+VALUES ($1, $2)
+ON CONFLICT (chirp_id, user_id) DO NOTHING;
+
+-- name: DeleteLike :exec
+DELETE FROM chirp_likes
+WHERE chirp_id = $1 AND user_id = $2;
+
+-- name: CountLikes :one
+SELECT COUNT(*)
+FROM chirp_likes
+WHERE chirp_id = $1;
@@ -0,0 +1,16 @@
+-- name: CreateChirpMention :exec
+INSERT INTO chirp_mentions (chirp_id, user_id)
+VALUES ($1, $2)
+ON CONFLICT (chirp_id, user_id) DO NOTHING;
+
+-- name: DeleteChirpMentions :exec
+DELETE FROM chirp_mentions
+WHERE chirp_id = $1;
+
+-- name: GetChirpsMentioningUser :many
+SELECT sqlc.embed(c), (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = c.id) AS likes
+FROM chirps c
+JOIN chirp_mentions m ON m.chirp_id = c.id
+WHERE m.user_id = $1 AND c.deleted_at IS NULL
+ORDER BY c.created_at DESC, c.id DESC
+LIMIT $2 OFFSET $3;
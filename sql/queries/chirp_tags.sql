@@ -0,0 +1,16 @@
+-- name: CreateChirpTag :exec
+INSERT INTO chirp_tags (chirp_id, tag)
+VALUES ($1, $2)
+ON CONFLICT (chirp_id, tag) DO NOTHING;
+
+-- name: DeleteChirpTags :exec
+DELETE FROM chirp_tags
+WHERE chirp_id = $1;
+
+-- name: GetChirpsByTag :many
+SELECT sqlc.embed(c), (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = c.id) AS likes
+FROM chirps c
+JOIN chirp_tags t ON t.chirp_id = c.id
+WHERE t.tag = $1 AND c.deleted_at IS NULL
+ORDER BY c.created_at DESC, c.id DESC
+LIMIT $2 OFFSET $3;
@@ -1,20 +1,115 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (body, user_id)
-VALUES ($1, $2)
-RETURNING id, created_at, updated_at, body, user_id;
--- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id
-FROM chirps
-ORDER BY created_at ASC;
+INSERT INTO chirps (body, user_id, parent_id)
+VALUES ($1, $2, $3)
+RETURNING id, created_at, updated_at, body, user_id, parent_id, deleted_at;
 -- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, parent_id, deleted_at
 FROM chirps
-WHERE id = $1;
--- name: DeleteChirp :exec
+WHERE id = $1 AND deleted_at IS NULL;
+-- name: SoftDeleteChirp :exec
+UPDATE chirps
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL;
+-- name: SoftDeleteChirpsByAuthor :execrows
+UPDATE chirps
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND deleted_at IS NULL;
+-- name: GetDeletedChirp :one
+SELECT id, created_at, updated_at, body, user_id, parent_id, deleted_at
+FROM chirps
+WHERE id = $1 AND deleted_at IS NOT NULL;
+-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING id, created_at, updated_at, body, user_id, parent_id, deleted_at;
+-- name: PurgeDeletedChirps :execrows
 DELETE FROM chirps
-WHERE id = $1;
--- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id
+WHERE deleted_at < $1;
+-- name: GetChirpsPaged :many
+SELECT sqlc.embed(chirps), (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
+FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('start')::timestamp IS NULL OR created_at >= sqlc.narg('start'))
+  AND (sqlc.narg('end')::timestamp IS NULL OR created_at < sqlc.narg('end'))
+ORDER BY
+    CASE WHEN sqlc.arg('sort_desc')::bool THEN created_at END DESC,
+    created_at ASC
+LIMIT sqlc.arg('row_limit') OFFSET sqlc.arg('row_offset');
+
+-- name: GetChirpsWithAuthor :many
+SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.parent_id, c.deleted_at, u.email AS author_email,
+       (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = c.id) AS likes
+FROM chirps c
+JOIN users u ON u.id = c.user_id
+WHERE c.deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR c.user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('start')::timestamp IS NULL OR c.created_at >= sqlc.narg('start'))
+  AND (sqlc.narg('end')::timestamp IS NULL OR c.created_at < sqlc.narg('end'))
+ORDER BY
+    CASE WHEN sqlc.arg('sort_desc')::bool THEN c.created_at END DESC,
+    c.created_at ASC
+LIMIT sqlc.arg('row_limit') OFFSET sqlc.arg('row_offset');
+
+-- name: GetChirpsAfter :many
+SELECT sqlc.embed(chirps), (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
+FROM chirps
+WHERE (created_at, id) > (sqlc.arg('cursor_created_at')::timestamp, sqlc.arg('cursor_id')::uuid)
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND deleted_at IS NULL
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('row_limit');
+
+-- name: GetChirpsBefore :many
+SELECT sqlc.embed(chirps), (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
+FROM chirps
+WHERE (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND deleted_at IS NULL
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('row_limit');
+-- name: UpdateChirp :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, parent_id, deleted_at;
+-- name: SearchChirps :many
+SELECT sqlc.embed(chirps), (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
+FROM chirps
+WHERE body ILIKE '%' || sqlc.arg('query')::text || '%' ESCAPE '\'
+  AND deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('start')::timestamp IS NULL OR created_at >= sqlc.narg('start'))
+  AND (sqlc.narg('end')::timestamp IS NULL OR created_at < sqlc.narg('end'))
+ORDER BY
+    CASE WHEN sqlc.arg('sort_desc')::bool THEN created_at END DESC,
+    created_at ASC
+LIMIT sqlc.arg('row_limit') OFFSET sqlc.arg('row_offset');
+-- name: GetChirpReplies :many
+SELECT sqlc.embed(chirps), (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = chirps.id) AS likes
 FROM chirps
-WHERE user_id = $1
+WHERE parent_id = $1 AND deleted_at IS NULL
 ORDER BY created_at ASC;
+-- name: GetFeed :many
+SELECT sqlc.embed(c), (SELECT COUNT(*) FROM chirp_likes l WHERE l.chirp_id = c.id) AS likes
+FROM chirps c
+JOIN follows f ON f.followee_id = c.user_id
+WHERE f.follower_id = $1 AND c.deleted_at IS NULL
+ORDER BY c.created_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: CountChirps :one
+SELECT COUNT(*) FROM chirps WHERE deleted_at IS NULL;
+
+-- name: CountChirpsByAuthor :one
+SELECT COUNT(*) FROM chirps WHERE user_id = $1 AND deleted_at IS NULL;
+
+-- name: CountUserChirpsSince :one
+-- Deleted chirps still count, so deleting doesn't refill the rate limit.
+SELECT COUNT(*) FROM chirps
+WHERE user_id = sqlc.arg('user_id') AND created_at > sqlc.arg('since');
+
+-- name: DeleteAllChirps :exec
+DELETE FROM chirps;
@@ -0,0 +1,9 @@
+-- name: CreateVerificationToken :exec
+INSERT INTO email_verification_tokens (token, user_id, expires_at)
+VALUES ($1, $2, $3);
+
+-- name: ConsumeVerificationToken :one
+DELETE FROM email_verification_tokens
+WHERE token = $1
+  AND expires_at > NOW()
+RETURNING user_id;
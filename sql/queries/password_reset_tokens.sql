@@ -0,0 +1,11 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (token, user_id, expires_at)
+VALUES ($1, $2, $3);
+
+-- name: ConsumePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE token = $1
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING user_id;
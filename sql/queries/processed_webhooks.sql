@@ -0,0 +1,8 @@
+-- name: RecordProcessedWebhook :execrows
+INSERT INTO processed_webhooks (event_id)
+VALUES ($1)
+ON CONFLICT (event_id) DO NOTHING;
+
+-- name: DeleteProcessedWebhook :exec
+DELETE FROM processed_webhooks
+WHERE event_id = $1;
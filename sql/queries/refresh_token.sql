@@ -2,8 +2,8 @@
 INSERT INTO refresh_tokens (token, user_id, expires_at)
 VALUES ($1, $2, $3);
 
--- name: GetUserFromRefreshToken :one
-SELECT u.id, u.email, u.hashed_password, u.created_at, u.updated_at
+-- name: GetUserFromValidRefreshToken :one
+SELECT u.id, u.email, u.created_at, u.updated_at
 FROM users u
 JOIN refresh_tokens rt ON rt.user_id = u.id
 WHERE rt.token = $1
@@ -20,3 +20,23 @@ SELECT token, user_id, created_at, updated_at, expires_at, revoked_at
 FROM refresh_tokens
 WHERE token = $1;
 
+
+-- name: GetActiveRefreshTokensForUser :many
+SELECT token, user_id, created_at, updated_at, expires_at, revoked_at
+FROM refresh_tokens
+WHERE user_id = $1
+  AND revoked_at IS NULL
+  AND expires_at > NOW()
+ORDER BY created_at DESC;
+
+-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+  AND revoked_at IS NULL;
+
+-- name: RevokeActiveRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE token = $1
+  AND revoked_at IS NULL;
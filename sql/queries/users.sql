@@ -1,41 +1,67 @@
--- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email)
-VALUES (
-    gen_random_uuid(),
-    NOW(),
-    NOW(),
-    $1
-)
-RETURNING *;
-
 -- name: GetUserByEmail :one
-SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red
+SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red, username, avatar_url, bio
 FROM users
-WHERE email = $1;
+WHERE lower(email) = lower($1);
+
+-- name: GetUserByUsername :one
+SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red, username, avatar_url, bio
+FROM users
+WHERE lower(username) = lower($1);
 
 -- name: CreateUserWithPassword :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, username)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
-RETURNING id, created_at, updated_at, email, is_chirpy_red;
+RETURNING id, created_at, updated_at, email, is_chirpy_red, username, avatar_url, bio;
 
 -- name: DeleteAllUsers :exec
 DELETE FROM users;
 
 -- name: UpdateUser :one
 UPDATE users
-SET email = $2,
-    hashed_password = $3,
+SET email = COALESCE(sqlc.narg('email'), email),
+    hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
+    avatar_url = COALESCE(sqlc.narg('avatar_url'), avatar_url),
+    bio = COALESCE(sqlc.narg('bio'), bio),
     updated_at = NOW()
-WHERE id = $1
-RETURNING id, email, created_at, updated_at, is_chirpy_red;
+WHERE id = sqlc.arg('id')
+RETURNING id, email, created_at, updated_at, is_chirpy_red, username, avatar_url, bio;
 
--- name: UpgradeUserToChirpyRed :exec
+-- name: UpgradeUserToChirpyRed :execrows
 UPDATE users
 SET is_chirpy_red = TRUE, updated_at = NOW()
 WHERE id = $1;
+
+-- name: DowngradeUserFromChirpyRed :execrows
+UPDATE users
+SET is_chirpy_red = FALSE, updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT id, email, created_at, updated_at, is_chirpy_red, is_verified, username, avatar_url, bio
+FROM users
+WHERE id = $1;
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: MarkUserVerified :exec
+UPDATE users
+SET is_verified = TRUE, updated_at = NOW()
+WHERE id = $1;
+
+-- name: CountUsers :one
+SELECT COUNT(*) FROM users;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Refresh tokens are now stored as their SHA-256 hex digest.
+UPDATE refresh_tokens
+SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Hashes can't be reversed, so rolling back invalidates every session.
+DELETE FROM refresh_tokens;
+-- +goose StatementEnd
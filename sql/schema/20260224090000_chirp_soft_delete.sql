@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE chirps
+ADD COLUMN deleted_at TIMESTAMP NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM chirps WHERE deleted_at IS NOT NULL;
+ALTER TABLE chirps
+DROP COLUMN deleted_at;
+-- +goose StatementEnd
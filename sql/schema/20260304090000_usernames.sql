@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN username TEXT NULL;
+CREATE UNIQUE INDEX users_username_lower_idx ON users (lower(username));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX users_username_lower_idx;
+ALTER TABLE users
+DROP COLUMN username;
+-- +goose StatementEnd
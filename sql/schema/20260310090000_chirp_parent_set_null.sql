@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_parent_id_fkey,
+ADD CONSTRAINT chirps_parent_id_fkey
+    FOREIGN KEY (parent_id) REFERENCES chirps(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_parent_id_fkey,
+ADD CONSTRAINT chirps_parent_id_fkey
+    FOREIGN KEY (parent_id) REFERENCES chirps(id) ON DELETE CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+DROP INDEX users_email_handle_idx;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE INDEX users_email_handle_idx ON users (lower(split_part(email, '@', 1)));
+-- +goose StatementEnd